@@ -3,11 +3,16 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/AnkanNandi/disvault/db"
 	"github.com/bwmarrin/discordgo"
@@ -17,6 +22,37 @@ import (
 type config struct {
 	BotToken  string `json:"bot_token"`
 	ChannelID string `json:"channel_id"`
+
+	// Passphrase used to encrypt the file names written into part messages, names are sent in the clear without it
+	EncryptionKey string `json:"encryption_key,omitempty"`
+
+	// Upload a backup of the database to the channel after every command that changed it
+	AutoBackup bool `json:"auto_backup,omitempty"`
+
+	// Days deleted files stay in the trash before they are purged from Discord,
+	// DefaultTrashDays when not set and never when negative
+	TrashDays int `json:"trash_days,omitempty"`
+
+	// Retention of the older versions of files, enforced by the prune command. Versions are kept
+	// while they are among the newest versions_keep or were replaced less than versions_keep_days ago,
+	// 0 turns a rule off.
+	VersionsKeep     int `json:"versions_keep,omitempty"`
+	VersionsKeepDays int `json:"versions_keep_days,omitempty"`
+}
+
+// DefaultTrashDays is how long deleted files stay in the trash unless trash_days is configured
+const DefaultTrashDays = 30
+
+// TrashRetention returns how long deleted files stay in the trash, ok is false when they are never purged
+func (c config) TrashRetention() (retention time.Duration, ok bool) {
+	days := c.TrashDays
+	if days == 0 {
+		days = DefaultTrashDays
+	}
+	if days < 0 {
+		return 0, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
 }
 
 var (
@@ -66,8 +102,9 @@ func loadConfig(filePath string) error {
 	return nil
 }
 
-// UploadFile uploads a file chunk using an existing Discord session
-func UploadFile(ctx context.Context, partName string, partsStruct *db.Parts) (string, error) {
+// UploadFile uploads a file chunk using an existing Discord session with content as the message text,
+// the part is added to partsStruct with the ID of the message holding it
+func UploadFile(ctx context.Context, partName string, content string, part db.Part, partsStruct *db.Parts) (string, error) {
 	f, err := os.Open(partName)
 	if err != nil {
 		return "", fmt.Errorf("could not open file: %w", err)
@@ -75,9 +112,10 @@ func UploadFile(ctx context.Context, partName string, partsStruct *db.Parts) (st
 	defer f.Close()
 
 	ms := &discordgo.MessageSend{
+		Content: content,
 		Files: []*discordgo.File{
 			{
-				Name:   partName,
+				Name:   filepath.Base(partName),
 				Reader: f,
 			},
 		},
@@ -86,11 +124,49 @@ func UploadFile(ctx context.Context, partName string, partsStruct *db.Parts) (st
 	if err != nil {
 		return "", fmt.Errorf("error sending message: %w", err)
 	}
-	partsStruct.Parts = append(partsStruct.Parts, msgSent.ID)
-	fmt.Printf("Message ID: %v\n", msgSent.ID)
+	part.MessageID = msgSent.ID
+	partsStruct.Parts = append(partsStruct.Parts, part)
+	fmt.Fprintf(db.Messages, "Message ID: %v\n", msgSent.ID)
 	return msgSent.ID, nil
 }
 
+// SendAttachment posts data as an attachment called name with content as the message text
+func SendAttachment(name string, data io.Reader, content string) (*discordgo.Message, error) {
+	msg, err := Session.ChannelMessageSendComplex(Config.ChannelID, &discordgo.MessageSend{
+		Content: content,
+		Files:   []*discordgo.File{{Name: name, Reader: data}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error sending message: %w", err)
+	}
+	return msg, nil
+}
+
+// PinMessage pins a message of the channel
+func PinMessage(messageID string) error {
+	if err := Session.ChannelMessagePin(Config.ChannelID, messageID); err != nil {
+		return fmt.Errorf("error pinning message %s: %w", messageID, err)
+	}
+	return nil
+}
+
+// UnpinMessage unpins a message of the channel
+func UnpinMessage(messageID string) error {
+	if err := Session.ChannelMessageUnpin(Config.ChannelID, messageID); err != nil {
+		return fmt.Errorf("error unpinning message %s: %w", messageID, err)
+	}
+	return nil
+}
+
+// PinnedMessages returns the pinned messages of the channel, newest first
+func PinnedMessages() ([]*discordgo.Message, error) {
+	messages, err := Session.ChannelMessagesPinned(Config.ChannelID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching pinned messages: %w", err)
+	}
+	return messages, nil
+}
+
 /*
 Downloads the parts in same order as uploaded and joins each part on the fly,
 since each file is added to the file before and then closed, no big memory usage is observed
@@ -99,16 +175,12 @@ since each file is added to the file before and then closed, no big memory usage
 	'TODO: Add a way to check for sudden stops in download or stich if possible i.e. program crash in middle of function'
 */
 func DownloadPart(partID string) ([]byte, error) {
-	msg, err := Session.ChannelMessage(Config.ChannelID, partID)
+	attachment, err := PartAttachment(partID)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving message: %w", err)
+		return nil, err
 	}
 
-	if len(msg.Attachments) == 0 {
-		return nil, fmt.Errorf("no attachments found in message: %s", partID)
-	}
-
-	res, err := http.Get(msg.Attachments[0].URL)
+	res, err := http.Get(attachment.URL)
 	if err != nil {
 		return nil, fmt.Errorf("error downloading file: %w", err)
 	}
@@ -121,3 +193,56 @@ func DownloadPart(partID string) ([]byte, error) {
 
 	return data, nil
 }
+
+// PartAttachment fetches the message of an uploaded part and returns the attachment holding the chunk
+func PartAttachment(partID string) (*discordgo.MessageAttachment, error) {
+	msg, err := Session.ChannelMessage(Config.ChannelID, partID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving message: %w", err)
+	}
+
+	if len(msg.Attachments) == 0 {
+		return nil, fmt.Errorf("no attachments found in message: %s", partID)
+	}
+	return msg.Attachments[0], nil
+}
+
+// ScanChannel pages through the messages of the channel from the newest to the oldest,
+// fn is called with every page of up to 100 messages until it returns an error or the history ends
+func ScanChannel(fn func(messages []*discordgo.Message) error) error {
+	before := ""
+	for {
+		messages, err := Session.ChannelMessages(Config.ChannelID, 100, before, "", "")
+		if err != nil {
+			return fmt.Errorf("error fetching channel messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		if err := fn(messages); err != nil {
+			return err
+		}
+		before = messages[len(messages)-1].ID
+	}
+}
+
+// IsUnknownMessage reports whether err means the message doesn't exist (anymore) on Discord
+func IsUnknownMessage(err error) bool {
+	// Only the error code tells, a 404 is also returned for an unknown channel or webhook
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownMessage
+}
+
+// AttachmentExpiry returns when a signed CDN URL of an attachment stops working.
+// Discord puts the expiry as a hex unix timestamp in the `ex` parameter, ok is false for unsigned URLs.
+func AttachmentExpiry(attachmentURL string) (expiry time.Time, ok bool) {
+	u, err := url.Parse(attachmentURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(u.Query().Get("ex"), 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the backup command
+var (
+	dirBackupGroup       string
+	dirBackupExclude     []string
+	dirBackupMarkRemoved bool
+	dirBackupDryRun      bool
+	dirBackupWorkers     int
+	dirBackupName        string
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup <dir>",
+	Short: "Upload the new and changed files of a directory",
+	Long: `Backup uploads the files of a directory and its subdirectories into a group, named by their
+path inside the directory. The size, modification time and hash of every file are remembered, so
+the next run only uploads the files that are new or changed, a changed file is stored as a new
+version of its previous upload. Files that were only touched are read once to compare their hash.
+
+The group has to be given on the first run of a directory and is remembered after that. Files
+that disappeared from the directory are kept, --mark-removed records them as removed so they are
+left out of the snapshot. Every run is recorded as a snapshot of the directory, --name names it,
+see the snapshots and restore commands.
+
+The exit code is nonzero if a file couldn't be backed up, so it can run from cron:
+	0 3 * * * cd /opt/disvault && disvault backup ~/Documents --mark-removed --format json
+
+Example usage:
+	disvault backup ~/Documents --group documents
+	disvault backup ~/Documents --name before-cleanup
+	disvault backup ~/Documents --exclude '*.tmp' --exclude node_modules
+	disvault backup ~/Documents --dry-run`,
+	Args: cobra.ExactArgs(1),
+	Run:  runBackupCmd,
+}
+
+func init() {
+	backupCmd.Flags().StringVarP(&dirBackupGroup, "group", "g", "", "Group the files are uploaded to (name or ID), required on the first run")
+	backupCmd.Flags().StringSliceVarP(&dirBackupExclude, "exclude", "e", nil, "Skip files and directories whose name or path matches the pattern, may be repeated")
+	backupCmd.Flags().BoolVar(&dirBackupMarkRemoved, "mark-removed", false, "Record the files missing from the directory as removed")
+	backupCmd.Flags().BoolVar(&dirBackupDryRun, "dry-run", false, "Show what would be uploaded without uploading anything")
+	backupCmd.Flags().StringVarP(&dirBackupName, "name", "n", "", "Name of the snapshot recorded for this run")
+	backupCmd.Flags().IntVarP(&dirBackupWorkers, "workers", "w", core.DefaultWorkers, "Number of files uploaded at the same time")
+
+	rootCmd.AddCommand(backupCmd)
+}
+
+// backupFileRecord is a file of a directory backup in the machine-readable output formats
+type backupFileRecord struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"` // Size in bytes
+	Status string `json:"status"`
+	FileID int    `json:"file_id"`
+	Error  string `json:"error"`
+}
+
+// dirBackupRecord is a run of the backup command in the machine-readable output formats
+type dirBackupRecord struct {
+	SnapshotID int                `json:"snapshot_id"` // 0 on a dry run
+	Name       string             `json:"name"`
+	Root       string             `json:"root"`
+	Created    *time.Time         `json:"created"`
+	Added      int                `json:"added"`
+	Changed    int                `json:"changed"`
+	Unchanged  int                `json:"unchanged"`
+	Removed    int                `json:"removed"`
+	Failed     int                `json:"failed"`
+	Files      []backupFileRecord `json:"files"`
+}
+
+func runBackupCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+	ctx := context.Background()
+
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatalf("Error resolving %s: %v", args[0], err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(db.Messages, "Error: %s is not a directory.\n", dir)
+		return
+	}
+
+	root, err := backupRoot(ctx, dir)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	// Check the name now rather than failing after everything was uploaded
+	if dirBackupName != "" {
+		if s, err := db.Default.ResolveSnapshot(ctx, dirBackupName); err == nil && s.Name == dirBackupName {
+			fmt.Fprintf(db.Messages, "Error: %v: %s\n", db.ErrSnapshotExists, dirBackupName)
+			return
+		}
+	}
+
+	result, err := core.BackupDirectory(ctx, root, core.DirBackupOptions{
+		Exclude:     dirBackupExclude,
+		MarkRemoved: dirBackupMarkRemoved,
+		DryRun:      dirBackupDryRun,
+		Workers:     dirBackupWorkers,
+		Name:        dirBackupName,
+	})
+	if !dirBackupDryRun {
+		markDatabaseChanged()
+	}
+	if err != nil {
+		autoBackup()
+		log.Fatalf("Error backing up %s: %v", dir, err)
+	}
+
+	if machineOutput() {
+		if err := render(stdout, newDirBackupRecord(root, result)); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		printDirBackup(stdout, root, result)
+	}
+	if result.Snapshot.Failed > 0 {
+		autoBackup()
+		os.Exit(1)
+	}
+}
+
+// backupRoot returns the backup root of dir, registering it on the first run. The group
+// of the root can't change, its files would stop being versions of the earlier uploads.
+func backupRoot(ctx context.Context, dir string) (db.BackupRoot, error) {
+	var groupID int
+	if dirBackupGroup != "" {
+		id, err := resolveGroup(dirBackupGroup)
+		if err != nil {
+			return db.BackupRoot{}, err
+		}
+		groupID = id
+	}
+
+	root, err := db.Default.BackupRootByPath(ctx, dir)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		if groupID == 0 {
+			return root, fmt.Errorf("%s is backed up for the first time, give the group with --group", dir)
+		}
+		if dirBackupDryRun {
+			// Nothing is recorded on a dry run, every file shows up as new
+			root.GroupID = groupID
+			return root, nil
+		}
+		return db.Default.CreateBackupRoot(ctx, dir, groupID)
+	case err != nil:
+		return root, err
+	case groupID != 0 && groupID != root.GroupID:
+		return root, fmt.Errorf("%s is backed up into group ID %d, leave out --group or give that one", dir, root.GroupID)
+	}
+	return root, nil
+}
+
+func newDirBackupRecord(root db.BackupRoot, result core.DirBackupResult) dirBackupRecord {
+	s := result.Snapshot
+	record := dirBackupRecord{
+		SnapshotID: s.ID,
+		Name:       s.Name,
+		Root:       root.Path,
+		Added:      s.Added,
+		Changed:    s.Changed,
+		Unchanged:  s.Unchanged,
+		Removed:    s.Removed,
+		Failed:     s.Failed,
+		Files:      make([]backupFileRecord, 0, len(result.Files)),
+	}
+	if !s.Created.IsZero() {
+		record.Created = &s.Created
+	}
+	for _, f := range result.Files {
+		file := backupFileRecord{Path: f.Path, Size: f.Size, Status: f.Status, FileID: f.FileID}
+		if f.Err != nil {
+			file.Error = f.Err.Error()
+		}
+		record.Files = append(record.Files, file)
+	}
+	return record
+}
+
+// printDirBackup writes the files a backup uploaded, removed or failed on as a table, followed by the totals
+func printDirBackup(w io.Writer, root db.BackupRoot, result core.DirBackupResult) {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "STATUS\tPATH\tFILE SIZE\tFILE ID\tERROR")
+	listed := 0
+	for _, f := range result.Files {
+		if f.Status == core.BackupUnchanged {
+			continue
+		}
+		fileID, errText := "-", ""
+		if f.FileID != 0 {
+			fileID = fmt.Sprint(f.FileID)
+		}
+		if f.Err != nil {
+			errText = f.Err.Error()
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", f.Status, f.Path, formatBytes(f.Size), fileID, errText)
+		listed++
+	}
+	if listed > 0 {
+		fmt.Fprintln(w)
+		writer.Flush()
+		fmt.Fprintln(w)
+	}
+
+	s := result.Snapshot
+	fmt.Fprintf(w, "%d added, %d changed, %d unchanged, %d removed, %d failed.\n", s.Added, s.Changed, s.Unchanged, s.Removed, s.Failed)
+	if s.ID == 0 {
+		fmt.Fprintln(w, "Dry run, nothing was uploaded.")
+		return
+	}
+	fmt.Fprintf(w, "Recorded snapshot %d of %s.\n", s.ID, root.Path)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"text/tabwriter"
+
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the local database",
+	Long: `The db commands look after the local database in data/db.sql.
+
+Every command upgrades the database to the schema it needs on its own, a copy of the database
+is saved to data/backups before it is changed. db migrate does the same explicitly and
+db status shows which version the database is at.
+
+db backup uploads a copy of the database to the channel and db restore brings it back,
+i.e. on a new machine.
+
+Example usage:
+	disvault db status
+	disvault db migrate
+	disvault db backup
+	disvault db restore`,
+}
+
+// dbMigrateCmd represents the db migrate command
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the database to the latest schema",
+	Args:  cobra.NoArgs,
+	Run:   runDBMigrateCmd,
+}
+
+// dbStatusCmd represents the db status command
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the schema version of the database and the pending migrations",
+	Args:  cobra.NoArgs,
+	Run:   runDBStatusCmd,
+}
+
+func init() {
+	dbCmd.AddCommand(dbMigrateCmd, dbStatusCmd)
+	rootCmd.AddCommand(dbCmd)
+}
+
+// migrationRecord is how a migration is written in the machine-readable output formats
+type migrationRecord struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+func runDBMigrateCmd(cmd *cobra.Command, args []string) {
+	if err := db.Open(); err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+
+	applied, backup, err := db.Default.Migrate(context.Background())
+	for _, migration := range applied {
+		fmt.Fprintf(db.Messages, "Applied migration %d: %s\n", migration.Version, migration.Name)
+	}
+	if backup != "" {
+		fmt.Fprintf(db.Messages, "The previous database was saved to %s\n", backup)
+	}
+	if err != nil {
+		log.Fatalf("Error migrating database: %v", err)
+	}
+	if len(applied) == 0 {
+		fmt.Fprintf(db.Messages, "The database is up to date at version %d.\n", db.LatestVersion())
+	}
+}
+
+func runDBStatusCmd(cmd *cobra.Command, args []string) {
+	if err := db.Open(); err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+
+	current, err := db.Default.SchemaVersion(context.Background())
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	records := []migrationRecord{}
+	for _, migration := range db.Migrations() {
+		records = append(records, migrationRecord{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: migration.Version <= current,
+		})
+	}
+
+	if machineOutput() {
+		if err := render(stdout, records); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	fmt.Fprintf(db.Messages, "Database:        %s\n", db.Path)
+	fmt.Fprintf(db.Messages, "Schema version:  %d\n", current)
+	fmt.Fprintf(db.Messages, "Latest version:  %d\n", db.LatestVersion())
+	switch {
+	case current > db.LatestVersion():
+		fmt.Fprintln(db.Messages, "The database was created by a newer disvault, update disvault to use it.")
+	case current < db.LatestVersion():
+		fmt.Fprintf(db.Messages, "%d migration(s) pending, run `disvault db migrate` or any other command to apply them.\n", db.LatestVersion()-current)
+	}
+	fmt.Fprintln(db.Messages)
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "VERSION\tNAME\tSTATUS")
+	for _, r := range records {
+		status := "pending"
+		if r.Applied {
+			status = "applied"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", r.Version, r.Name, status)
+	}
+	writer.Flush()
+}
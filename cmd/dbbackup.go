@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the db backup and db restore commands
+var (
+	backupPlain    bool
+	restoreMessage string
+	restoreYes     bool
+)
+
+// dbBackupCmd represents the db backup command
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Upload a copy of the database to the channel",
+	Long: `Backup uploads a snapshot of data/db.sql to the channel as a pinned message, so the index
+of the vault survives losing the machine it runs on. The snapshot is encrypted when an encryption
+key is configured, older backups are unpinned but stay in the channel. A snapshot bigger than one
+attachment is split into parts sent as separate messages, the pinned one lists the others.
+
+Set "auto_backup": true in data/config.json (or run setup with --auto-backup) to back up the
+database after every command that changes it.
+
+Example usage:
+	disvault db backup
+	disvault db backup --plain`,
+	Args: cobra.NoArgs,
+	Run:  runDBBackupCmd,
+}
+
+// dbRestoreCmd represents the db restore command
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Replace the database with a backup from the channel",
+	Long: `Restore downloads the latest backup made by db backup, or the one in --message, and
+replaces data/db.sql with it. The current database is saved to data/backups first and the
+restored one is upgraded to the latest schema.
+
+Example usage:
+	disvault db restore
+	disvault db restore --message 1234567890123456789 --yes`,
+	Args: cobra.NoArgs,
+	Run:  runDBRestoreCmd,
+}
+
+func init() {
+	dbBackupCmd.Flags().BoolVar(&backupPlain, "plain", false, "Don't encrypt the backup even if an encryption key is configured")
+	dbRestoreCmd.Flags().StringVarP(&restoreMessage, "message", "m", "", "ID of the backup message to restore instead of the latest")
+	dbRestoreCmd.Flags().BoolVarP(&restoreYes, "yes", "y", false, "Don't ask for confirmation")
+
+	dbCmd.AddCommand(dbBackupCmd, dbRestoreCmd)
+}
+
+// backupRecord is how a database backup is written in the machine-readable output formats
+type backupRecord struct {
+	MessageID string    `json:"message_id"`
+	Created   time.Time `json:"created"`
+	Schema    int       `json:"schema"`
+	Encrypted bool      `json:"encrypted"`
+	Size      int64     `json:"size"`  // Size in bytes
+	Parts     int       `json:"parts"` // Number of messages holding the backup
+}
+
+func newBackupRecord(b core.DatabaseBackup) backupRecord {
+	return backupRecord{
+		MessageID: b.MessageID,
+		Created:   b.Created,
+		Schema:    b.Schema,
+		Encrypted: b.Encrypted,
+		Size:      b.Size,
+		Parts:     len(b.PartIDs) + 1,
+	}
+}
+
+func runDBBackupCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	backup, err := core.BackupDatabase(context.Background(), !backupPlain && app.Config.EncryptionKey != "")
+	if err != nil {
+		log.Fatalf("Error backing up the database: %v", err)
+	}
+
+	if machineOutput() {
+		if err := render(stdout, newBackupRecord(backup)); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	printBackup(stdout, backup)
+}
+
+func runDBRestoreCmd(cmd *cobra.Command, args []string) {
+	app.Init()
+
+	var backup core.DatabaseBackup
+	var err error
+	if restoreMessage != "" {
+		backup, err = core.BackupByMessage(restoreMessage)
+	} else {
+		backup, err = core.LatestBackup()
+	}
+	if errors.Is(err, core.ErrNoBackup) {
+		fmt.Fprintln(db.Messages, "No database backup was found in the channel, run `disvault db backup` first.")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error finding the backup: %v", err)
+	}
+
+	printBackup(stdout, backup)
+	if backup.Schema > db.LatestVersion() {
+		log.Fatalf("Error: the backup is at schema version %d but this disvault only knows up to version %d, update disvault", backup.Schema, db.LatestVersion())
+	}
+	if !restoreYes && !confirm(fmt.Sprintf("Replace %s with this backup?", db.Path)) {
+		fmt.Fprintln(db.Messages, "Aborted.")
+		return
+	}
+
+	if err := os.MkdirAll(db.DataDir, 0755); err != nil {
+		log.Fatalf("Error creating data directory: %v", err)
+	}
+	download := db.Path + ".restore"
+	defer os.Remove(download)
+	if err := core.DownloadBackup(backup, download); err != nil {
+		log.Fatalf("Error downloading the backup: %v", err)
+	}
+
+	// Keep the database being replaced, it may hold changes made after the backup
+	if _, err := os.Stat(db.Path); err == nil {
+		if err := db.Open(); err != nil {
+			log.Fatalf("Error opening database: %v", err)
+		}
+		previous := filepath.Join(db.BackupDir, fmt.Sprintf("db-before-restore-%s.sql", time.Now().Format("20060102-150405")))
+		if err := db.Default.BackupTo(context.Background(), previous); err != nil {
+			log.Fatalf("Error saving the current database: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			log.Fatalf("Error closing database: %v", err)
+		}
+		fmt.Fprintf(db.Messages, "The current database was saved to %s\n", previous)
+	}
+
+	// The journal files belong to the old database and would be applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(db.Path + suffix); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Error removing %s: %v", db.Path+suffix, err)
+		}
+	}
+	if err := os.Rename(download, db.Path); err != nil {
+		log.Fatalf("Error replacing the database: %v", err)
+	}
+
+	if err := db.InitDatabase(); err != nil {
+		log.Fatalf("Error opening the restored database: %v", err)
+	}
+	fmt.Fprintf(db.Messages, "Database restored from the backup of %s.\n", backup.Created.Local().Format("2006-01-02 15:04:05"))
+}
+
+// printBackup describes a database backup
+func printBackup(w io.Writer, b core.DatabaseBackup) {
+	encrypted := "no"
+	if b.Encrypted {
+		encrypted = "yes"
+	}
+	fmt.Fprintf(w, "Backup message:  %s\n", b.MessageID)
+	fmt.Fprintf(w, "Created:         %s\n", b.Created.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Schema version:  %d\n", b.Schema)
+	fmt.Fprintf(w, "Size:            %s\n", formatBytes(b.Size))
+	if len(b.PartIDs) > 0 {
+		fmt.Fprintf(w, "Parts:           %d\n", len(b.PartIDs)+1)
+	}
+	fmt.Fprintf(w, "Encrypted:       %s\n", encrypted)
+}
+
+// databaseChanged is set by commands once they changed the database, see autoBackup
+var databaseChanged atomic.Bool
+
+// markDatabaseChanged records that the running command changed the database
+func markDatabaseChanged() {
+	databaseChanged.Store(true)
+}
+
+// autoBackup uploads a backup of the database when auto_backup is configured and the command changed
+// the database. A failed backup is reported but doesn't fail the command, its changes are already saved.
+func autoBackup() {
+	if !databaseChanged.Swap(false) || !app.Config.AutoBackup {
+		return
+	}
+	backup, err := core.BackupDatabase(context.Background(), app.Config.EncryptionKey != "")
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Warning: automatic database backup failed: %v\n", err)
+		return
+	}
+	fmt.Fprintf(db.Messages, "Database backed up to message %s.\n", backup.MessageID)
+}
@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/AnkanNandi/disvault/app"
 	"github.com/AnkanNandi/disvault/core"
@@ -10,19 +12,59 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Flags for the delete command
+var (
+	deleteGroupFilter string
+	deleteSearch      string
+	deleteOlderThan   string
+	deleteAll         bool
+	deleteYes         bool
+	deletePending     bool
+	deletePermanent   bool
+	deleteWorkers     int
+)
+
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
-	Use:   "delete <file_id>",
-	Short: "Delete files using their IDs",
-	Long: `Delete a file using its registered ID, similar to how downloads work.
-
-File parts are deleted first, followed by the main file registration in the database.
-For example, if a file has 10 parts, all parts will be deleted before the main file registration is removed.`,
-	Args: cobra.ExactArgs(1), // Ensure exactly one argument is provided
-	Run:  runDeleteCmd,
+	Use:   "delete [file_id...]",
+	Short: "Delete files using their IDs or filters",
+	Long: `Delete files using their registered IDs, ID ranges or filters, similar to how downloads work.
+
+Deleted files are moved to the trash first, see the trash command. They are hidden from the other
+commands and purged from Discord once they were in the trash for trash_days (30 by default),
+--permanent skips the trash. A file is deleted together with all of its versions, the prune
+command removes only the older versions.
+
+On a permanent delete the file parts are deleted first, followed by the main file registration in the database.
+For example, if a file has 10 parts, all parts will be deleted before the main file registration is removed.
+A delete that stopped midway, i.e. because of a crash or a network error, leaves the file pending:
+it is hidden from the other commands and deleting it again finishes the job, --pending selects
+every pending file.
+
+The files that are going to be removed are shown together with their total size and
+you are asked for confirmation, pass --yes to skip the question (i.e. in scripts).
+All the given IDs and filters have to match for a file to be deleted.
+
+Example usage:
+	disvault delete 4
+	disvault delete 3 5 7-12
+	disvault delete --group old-backups --older-than 30d
+	disvault delete --all --yes
+	disvault delete 4 --permanent
+	disvault delete --pending`,
+	Run: runDeleteCmd,
 }
 
 func init() {
+	deleteCmd.Flags().StringVarP(&deleteGroupFilter, "group", "g", "", "Delete the files of a group (name or ID)")
+	deleteCmd.Flags().StringVarP(&deleteSearch, "search", "s", "", "Delete the files whose name contains the keywords")
+	deleteCmd.Flags().StringVar(&deleteOlderThan, "older-than", "", "Delete the files uploaded before this long ago, i.e. 12h, 30d, 2w")
+	deleteCmd.Flags().BoolVar(&deleteAll, "all", false, "Delete every file in the vault")
+	deleteCmd.Flags().BoolVar(&deletePermanent, "permanent", false, "Delete the files right away instead of moving them to the trash")
+	deleteCmd.Flags().BoolVar(&deletePending, "pending", false, "Finish the deletes that stopped midway")
+	deleteCmd.Flags().BoolVarP(&deleteYes, "yes", "y", false, "Don't ask for confirmation")
+	deleteCmd.Flags().IntVarP(&deleteWorkers, "workers", "w", core.DefaultWorkers, "Number of files deleted at the same time")
+
 	rootCmd.AddCommand(deleteCmd)
 }
 
@@ -30,23 +72,131 @@ func init() {
 func runDeleteCmd(cmd *cobra.Command, args []string) {
 	db.InitDatabase()
 	app.Init()
-	// Parse the file ID from the argument
-	fileID, err := ParseFileID(args[0])
+
+	ids, err := ParseFileIDs(args)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		cmd.Help()
+		return
+	}
+	age, err := parseAge(deleteOlderThan)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+
+	// Files are moved to the trash unless asked otherwise. Permanent deletes also pick files
+	// in the trash and pending ones, deleting a pending file again resumes its delete.
+	permanent := deletePermanent || deletePending
+	selector := fileSelector{ids: ids, group: deleteGroupFilter, search: deleteSearch, olderThan: age, all: deleteAll}
+	if permanent {
+		selector.states = db.FileStates
+	}
+	if deletePending {
+		selector.all = true
+		selector.states = []string{db.StateDeleting}
+		selector.versions = true
+	}
+	if selector.empty() {
+		fmt.Fprintln(db.Messages, "Error: Provide file IDs, a filter or --all to select the files to delete.")
 		cmd.Help()
 		return
 	}
 
-	// Fetch the file to ensure it exists before deletion
-	if _, err := FetchFileNameByID(fileID); err != nil {
-		log.Fatalf("Error fetching file: %v", err)
+	files, err := selectFiles(selector)
+	if err == nil && permanent && !deletePending {
+		// The older versions go together with the file
+		files, err = withVersions(files)
+	}
+	if err != nil {
+		log.Fatalf("Error fetching files: %v", err)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(db.Messages, "No files matched your criteria.")
+		return
+	}
+
+	// Show what is going to be removed before touching anything
+	listAllFiles(files)
+	if permanent {
+		fmt.Fprintf(db.Messages, "\n%d file(s), %s in total will be deleted permanently.\n", len(files), formatBytes(totalSize(files)))
+	} else {
+		fmt.Fprintf(db.Messages, "\n%d file(s), %s in total will be moved to the trash.\n", len(files), formatBytes(totalSize(files)))
+	}
+	if !deleteYes && !confirm("Do you want to continue?") {
+		fmt.Fprintln(db.Messages, "Aborted, nothing was deleted.")
+		return
+	}
+	markTrashPurgeDue()
+
+	if !permanent {
+		moved, err := db.Default.TrashFiles(context.Background(), fileIDs(files))
+		if err != nil {
+			log.Fatalf("Error moving files to the trash: %v", err)
+		}
+		markDatabaseChanged()
+		if machineOutput() {
+			results := make([]fileResult, len(files))
+			for i, f := range files {
+				results[i] = newFileResult(f, nil)
+			}
+			if err := render(stdout, results); err != nil {
+				log.Fatalf("Failed to write output: %v", err)
+			}
+			return
+		}
+		fmt.Fprintf(db.Messages, "Moved %d file(s) to the trash, `disvault trash restore` brings them back.\n", moved)
+		if retention, ok := app.Config.TrashRetention(); ok {
+			fmt.Fprintf(db.Messages, "Files are purged from Discord after %d day(s) in the trash.\n", int(retention.Hours()/24))
+		}
+		return
+	}
+
+	results, failed := deletePermanently(files, deleteWorkers)
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Failed to write output: %v", err)
+		}
+	} else {
+		fmt.Fprintf(db.Messages, "Deleted %d file(s), %d failed.\n", len(files)-failed, failed)
 	}
+	if failed > 0 {
+		fmt.Fprintln(db.Messages, "The failed files are pending, run `disvault delete --pending` to finish deleting them.")
+		// Exiting skips the hooks of the root command
+		autoBackup()
+		os.Exit(1)
+	}
+}
 
-	// Delete the file parts and then the main file registration
-	if err := core.DeleteFileParts(fileID); err != nil {
-		log.Fatalf("Failed to delete file: %v", err)
+// deletePermanently deletes the parts of the files from Discord and the files from the database
+// using workers files at a time, it returns the result of every file and how many failed
+func deletePermanently(files []db.File, workers int) ([]fileResult, int) {
+	jobs := make([]core.Job, len(files))
+	for i, file := range files {
+		fileID := file.ID
+		jobs[i] = func() error { return core.DeleteFileParts(fileID) }
 	}
+	errs := core.NewExecutor(workers).Run(jobs)
 
-	fmt.Println("File deleted successfully.")
+	failed := 0
+	results := make([]fileResult, len(files))
+	for i, err := range errs {
+		results[i] = newFileResult(files[i], err)
+		if err != nil {
+			failed++
+			fmt.Fprintf(db.Messages, "Failed to delete file %d (%s): %v\n", files[i].ID, files[i].Name, err)
+		}
+	}
+	// Failed deletes leave their files pending, that is a change too
+	markDatabaseChanged()
+	return results, failed
+}
+
+// fileIDs returns the IDs of the files
+func fileIDs(files []db.File) []int {
+	ids := make([]int, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	return ids
 }
@@ -1,10 +1,14 @@
 package cmd
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/AnkanNandi/disvault/app"
 	"github.com/AnkanNandi/disvault/core"
@@ -12,20 +16,59 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Flags for the download command
+var (
+	downloadGroup     string
+	downloadSearch    string
+	downloadWorkers   int
+	downloadOutput    string
+	downloadOverwrite bool
+	downloadSkip      bool
+	downloadRename    bool
+	downloadPreserve  bool
+	downloadVersion   int
+)
+
 // downloadCmd represents the download command
 var downloadCmd = &cobra.Command{
-	Use:   "download <file_id>",
-	Short: "Download files using their IDs",
-	Long: `Download command allows for downloading files using their ID only.
-The files are saved in the 'output' folder with the same name as during upload.
+	Use:   "download [file_id...]",
+	Short: "Download files using their IDs or filters",
+	Long: `Download command allows for downloading files using their IDs, ID ranges or filters.
+The files are saved in the 'out' folder with the same name as during upload,
+use --output to save them somewhere else. When downloading a single file --output
+may be a file name, otherwise it is treated as a directory.
+
+Existing files are never replaced unless asked to, pick --overwrite, --skip or --rename
+to decide what happens when the output file already exists. A download is written to a
+temporary file first, so a failed download never leaves a truncated file behind.
+
+Several files are downloaded at the same time, a failed file doesn't stop the others.
+A summary is printed at the end and the exit code is only nonzero if a download failed.
+All the given IDs and filters have to match for a file to be downloaded.
+
+The current version of a file is downloaded, pick an older one of a single file with
+--version, the versions command lists them.
 
 Example usage:
-	disvault download <file_id>`,
-	Args: cobra.ExactArgs(1), // Ensure exactly one argument is provided
-	Run:  runDownloadCmd,
+	disvault download <file_id>
+	disvault download 3 5 7-12 --search invoice --group 4
+	disvault download 4 -o ~/Documents/report.pdf --overwrite
+	disvault download 4 --version 2`,
+	Run: runDownloadCmd,
 }
 
 func init() {
+	downloadCmd.Flags().StringVarP(&downloadGroup, "group", "g", "", "Download the files of a group (name or ID)")
+	downloadCmd.Flags().StringVarP(&downloadSearch, "search", "s", "", "Download the files whose name contains the keywords")
+	downloadCmd.Flags().IntVarP(&downloadWorkers, "workers", "w", core.DefaultWorkers, "Number of files downloaded at the same time")
+	downloadCmd.Flags().StringVarP(&downloadOutput, "output", "o", "", "Output file or directory, defaults to the 'out' directory")
+	downloadCmd.Flags().BoolVar(&downloadOverwrite, "overwrite", false, "Replace output files that already exist")
+	downloadCmd.Flags().BoolVar(&downloadSkip, "skip", false, "Skip files whose output file already exists")
+	downloadCmd.Flags().BoolVar(&downloadRename, "rename", false, "Save as 'name (1).ext' when the output file already exists")
+	downloadCmd.Flags().BoolVar(&downloadPreserve, "preserve", false, "Restore the modification time and permissions the file had when it was uploaded")
+	downloadCmd.Flags().IntVar(&downloadVersion, "version", 0, "Download this version of the file instead of the current one")
+	downloadCmd.MarkFlagsMutuallyExclusive("overwrite", "skip", "rename")
+
 	rootCmd.AddCommand(downloadCmd)
 }
 
@@ -33,26 +76,133 @@ func init() {
 func runDownloadCmd(cmd *cobra.Command, args []string) {
 	db.InitDatabase()
 	app.Init()
-	// Convert the file ID argument from string to integer
-	fileID, err := ParseFileID(args[0])
+	// Convert the file ID arguments from string to integers
+	ids, err := ParseFileIDs(args)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
 		cmd.Help()
 		return
 	}
 
-	// Check if the file exists and retrieve its name
-	fileName, err := FetchFileNameByID(fileID)
-	if err != nil {
-		log.Fatalf("Failed to fetch file: %v", err)
+	selector := fileSelector{ids: ids, group: downloadGroup, search: downloadSearch}
+	if selector.empty() {
+		fmt.Fprintln(db.Messages, "Error: Provide file IDs or a filter to select the files to download.")
+		cmd.Help()
+		return
+	}
+
+	var files []db.File
+	if downloadVersion != 0 {
+		if len(ids) != 1 || ids[0].From != ids[0].To || downloadGroup != "" || downloadSearch != "" {
+			fmt.Fprintln(db.Messages, "Error: --version needs exactly one file ID and no filters.")
+			return
+		}
+		file, err := fileVersion(ids[0].From, downloadVersion)
+		if err != nil {
+			fmt.Fprintf(db.Messages, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		files = []db.File{file}
+	} else {
+		files, err = selectFiles(selector)
+		if err != nil {
+			log.Fatalf("Failed to fetch files: %v", err)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(db.Messages, "No files matched your criteria.")
+		os.Exit(1)
+	}
+
+	opts := core.DownloadOptions{Policy: core.FailIfExists, Preserve: downloadPreserve}
+	switch {
+	case downloadOverwrite:
+		opts.Policy = core.Overwrite
+	case downloadSkip:
+		opts.Policy = core.Skip
+	case downloadRename:
+		opts.Policy = core.Rename
+	}
+
+	// Download and reassemble the files on a shared worker pool
+	savedPaths := make([]string, len(files))
+	jobs := make([]core.Job, len(files))
+	for i, file := range files {
+		jobs[i] = func() error {
+			path, err := outputPathFor(file.Name, len(files) > 1)
+			if err != nil {
+				return err
+			}
+			path, err = core.DownloadAndReassembleFile(file.ID, path, opts)
+			savedPaths[i] = path
+			return err
+		}
 	}
+	errs := core.NewExecutor(downloadWorkers).Run(jobs)
 
-	// Download and reassemble the file
-	if err := core.DownloadAndReassembleFile(fileID, fileName); err != nil {
-		log.Fatalf("Failed to download file: %v", err)
+	failed, skipped := 0, 0
+	results := make([]fileResult, len(files))
+	for i, err := range errs {
+		results[i] = newFileResult(files[i], err)
+		results[i].Path = savedPaths[i]
+		switch {
+		case errors.Is(err, core.ErrSkipped):
+			skipped++
+			results[i].Status = statusSkipped
+		case err != nil:
+			failed++
+		}
+	}
+	// IDs that don't exist fail the download too, so scripts notice them
+	if downloadVersion == 0 {
+		for _, id := range missingIDs(selector, files) {
+			failed++
+			results = append(results, fileResult{ID: id, Status: statusFailed, Error: "no file found with this ID"})
+		}
+	}
+
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Failed to write output: %v", err)
+		}
+	} else {
+		fmt.Fprintln(db.Messages, "\nDownload summary:")
+		for _, r := range results {
+			switch r.Status {
+			case statusSkipped:
+				fmt.Fprintf(db.Messages, "  SKIPPED %d (%s): %s\n", r.ID, r.Name, r.Error)
+			case statusFailed:
+				fmt.Fprintf(db.Messages, "  FAILED  %d (%s): %s\n", r.ID, r.Name, r.Error)
+			default:
+				fmt.Fprintf(db.Messages, "  OK      %d (%s) -> %s\n", r.ID, r.Name, r.Path)
+			}
+		}
+		fmt.Fprintf(db.Messages, "Downloaded %d file(s), %d skipped, %d failed.\n", len(results)-failed-skipped, skipped, failed)
+	}
+	if failed > 0 {
+		os.Exit(1)
 	}
+}
 
-	fmt.Println("File downloaded successfully.")
+// outputPathFor decides where a downloaded file is saved based on the --output flag.
+// The output is treated as a directory when it already is one, ends with a path separator
+// or more than one file is downloaded, otherwise it is the path of the file itself.
+// Names that would end up outside of the directory, i.e. from an imported manifest, are refused.
+func outputPathFor(fileName string, multiple bool) (string, error) {
+	isDir := downloadOutput == "" || multiple || strings.HasSuffix(downloadOutput, "/") || strings.HasSuffix(downloadOutput, string(filepath.Separator))
+	if info, err := os.Stat(downloadOutput); err == nil && info.IsDir() {
+		isDir = true
+	}
+	if !isDir {
+		return downloadOutput, nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(fileName)) {
+		return "", fmt.Errorf("refusing to save %q outside of the output directory", fileName)
+	}
+	if downloadOutput == "" {
+		return core.DefaultOutputPath(fileName), nil
+	}
+	return filepath.Join(downloadOutput, fileName), nil
 }
 
 // parseFileID converts a string file ID to an integer and validates it
@@ -66,13 +216,9 @@ func ParseFileID(fileIDStr string) (int, error) {
 
 // fetchFileNameByID checks if the file exists in the database and returns its name
 func FetchFileNameByID(id int) (string, error) {
-	var fileName string
-	err := db.DB.QueryRow("SELECT name FROM files WHERE id = ?", id).Scan(&fileName)
-	switch {
-	case err == sql.ErrNoRows:
-		return "", fmt.Errorf("no file found with ID: %d", id)
-	case err != nil:
-		return "", fmt.Errorf("error fetching file name: %v", err)
+	file, err := db.Default.GetFile(context.Background(), id)
+	if err != nil {
+		return "", err
 	}
-	return fileName, nil
+	return file.Name, nil
 }
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the export command
+var exportOutput string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the catalog of the vault to a manifest",
+	Long: `Export writes every group and file with its hashes, tags, notes and part messages to a JSON
+manifest. Anyone with a bot in the same channel can merge the manifest into their own database
+with the import command and download the files, without copying data/db.sql around.
+
+The manifest is written to stdout unless --output is given, --format yaml writes it as YAML
+for reading but only JSON manifests can be imported.
+
+Example usage:
+	disvault export --format json > vault.json
+	disvault export --output vault.json`,
+	Args: cobra.NoArgs,
+	Run:  runExportCmd,
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File the manifest is written to instead of stdout")
+
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runExportCmd(cmd *cobra.Command, args []string) {
+	if outputFormat == formatCSV {
+		log.Fatalf("Error: the manifest can't be written as CSV, use --format json or yaml")
+	}
+
+	db.InitDatabase()
+	app.Init()
+
+	manifest, err := db.Default.Export(context.Background())
+	if err != nil {
+		log.Fatalf("Error exporting the catalog: %v", err)
+	}
+	manifest.ChannelID = app.Config.ChannelID
+
+	var w io.Writer = stdout
+	if exportOutput != "" {
+		file, err := os.Create(exportOutput)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", exportOutput, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if outputFormat == formatYAML {
+		err = renderYAML(w, reflect.ValueOf(manifest), 0)
+	} else {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(manifest)
+	}
+	if err != nil {
+		log.Fatalf("Error writing the manifest: %v", err)
+	}
+
+	if exportOutput != "" {
+		fmt.Fprintf(db.Messages, "Exported %d group(s) and %d file(s) to %s\n", len(manifest.Groups), len(manifest.Files), exportOutput)
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the gc command
+var (
+	gcApply  bool
+	gcYes    bool
+	gcMinAge string
+)
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Find and clean up orphaned messages, dangling parts and incomplete files",
+	Long: `Gc reads the whole history of the channel and compares it with the database. It reports:
+
+  orphan messages   attachments posted by the bot that no registered part points to, left behind
+                    by uploads that crashed before the file was registered
+  dangling parts    registered parts whose message isn't in the channel anymore
+  incomplete files  files with fewer parts than they were split into, they can't be downloaded
+
+Nothing is changed unless --apply is given. Then dangling parts are removed from the database,
+incomplete files are repaired when their missing parts are among the orphan messages and deleted
+otherwise, and the remaining orphan messages are deleted from the channel. Orphan messages that
+make up a whole file are kept, run the rebuild command to register them.
+
+Messages posted less than --min-age ago are never taken for orphans, they may belong to an
+upload, backup or watch that is still running and registers its file once every part is sent.
+
+Example usage:
+	disvault gc
+	disvault gc --apply
+	disvault gc --apply --min-age 1d`,
+	Args: cobra.NoArgs,
+	Run:  runGCCmd,
+}
+
+func init() {
+	gcCmd.Flags().BoolVar(&gcApply, "apply", false, "Delete and repair what was found")
+	gcCmd.Flags().BoolVarP(&gcYes, "yes", "y", false, "Don't ask for confirmation")
+	gcCmd.Flags().StringVar(&gcMinAge, "min-age", core.DefaultGCMinAge.String(), "Leave messages younger than this alone, i.e. 30m, 12h or 2d")
+
+	rootCmd.AddCommand(gcCmd)
+}
+
+// Kinds of a gcRecord
+const (
+	gcOrphan     = "orphan"
+	gcDangling   = "dangling"
+	gcIncomplete = "incomplete"
+)
+
+// gcRecord is a finding of gc in the machine-readable output formats
+type gcRecord struct {
+	Kind      string `json:"kind"`
+	MessageID string `json:"message_id"`
+	FileID    int    `json:"file_id"`
+	Name      string `json:"name"`
+	Action    string `json:"action"` // What --apply does about it
+}
+
+func runGCCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+	ctx := context.Background()
+
+	minAge, err := parseAge(gcMinAge)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	report, err := core.ScanGarbage(ctx, minAge)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	records := gcRecords(report)
+
+	if machineOutput() {
+		if err := render(stdout, records); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		printGCReport(stdout, report, records)
+	}
+
+	if len(records) == 0 {
+		return
+	}
+	if !gcApply {
+		fmt.Fprintln(db.Messages, "Nothing was changed, run with --apply to clean up.")
+		return
+	}
+	if !gcYes && !confirm(fmt.Sprintf("Clean up these %d item(s)?", len(records))) {
+		fmt.Fprintln(db.Messages, "Aborted.")
+		return
+	}
+
+	// Even a partial clean up changes the database
+	markDatabaseChanged()
+	if err := core.CollectGarbage(ctx, report); err != nil {
+		autoBackup()
+		log.Fatalf("Error: %v", err)
+	}
+	fmt.Fprintln(db.Messages, "Clean up finished.")
+}
+
+// gcRecords lists the findings of a report with what --apply does about each of them
+func gcRecords(report core.GarbageReport) []gcRecord {
+	records := []gcRecord{}
+	for _, f := range report.Incomplete {
+		action := "delete file"
+		if f.Repair != nil {
+			action = fmt.Sprintf("repair with %d part(s) from the channel", len(f.Repair))
+		}
+		records = append(records, gcRecord{Kind: gcIncomplete, FileID: f.FileID, Name: f.FileName, Action: action})
+	}
+	for _, d := range report.Dangling {
+		records = append(records, gcRecord{Kind: gcDangling, MessageID: d.MessageID, FileID: d.FileID, Name: d.FileName, Action: "remove part"})
+	}
+	for _, o := range report.Orphans {
+		records = append(records, gcRecord{Kind: gcOrphan, MessageID: o.MessageID, Action: "delete message"})
+	}
+	return records
+}
+
+// printGCReport writes the findings of gc as a table
+func printGCReport(w io.Writer, report core.GarbageReport, records []gcRecord) {
+	fmt.Fprintf(w, "Scanned %d message(s).\n", report.Scanned)
+	if report.Recoverable > 0 {
+		fmt.Fprintf(w, "%d file(s) in the channel aren't in the database but are complete, run `disvault rebuild` to register them.\n", report.Recoverable)
+	}
+	if report.Recent > 0 {
+		fmt.Fprintf(w, "%d recent message(s) aren't in the database yet and were left alone, they may belong to a running upload.\n", report.Recent)
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(w, "Nothing to clean up.")
+		return
+	}
+	fmt.Fprintln(w)
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "KIND\tMESSAGE ID\tFILE ID\tFILE NAME\tACTION")
+	for _, r := range records {
+		messageID, fileID, name := "-", "-", "-"
+		if r.MessageID != "" {
+			messageID = r.MessageID
+		}
+		if r.FileID != 0 {
+			fileID = fmt.Sprint(r.FileID)
+			name = r.Name
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", r.Kind, messageID, fileID, name, r.Action)
+	}
+	writer.Flush()
+
+	fmt.Fprintf(w, "\n%d incomplete file(s), %d dangling part(s), %d orphan message(s).\n",
+		len(report.Incomplete), len(report.Dangling), len(report.Orphans))
+}
@@ -1,40 +1,57 @@
 package cmd
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 	"text/tabwriter"
 
 	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
 	"github.com/AnkanNandi/disvault/db"
 	"github.com/spf13/cobra"
 )
 
 // Flags for the group command
 var (
-	group           string
-	parentGroup     string
-	deleteGroupName string
-	listGroups      bool
+	group            string
+	parentGroup      string
+	deleteGroupName  string
+	listGroups       bool
+	reassignTo       string
+	deleteGroupFiles bool
+	deleteDryRun     bool
 )
 
 // groupCmd represents the group command
 var groupCmd = &cobra.Command{
 	Use:   "group",
 	Short: "Manage groups within DisVault",
-	Long:  `Group command allows you to create, delete, and manage groups within DisVault.`,
-	Run:   runGroupCmd,
+	Long: `Group command allows you to create, delete, and manage groups within DisVault.
+
+Deleting a group also deletes all of its child groups. By default a group that still
+contains files or child groups is not deleted, pick what happens to its files with
+--reassign-to or --delete-files, and use --dry-run to preview the affected groups and files.
+
+Example usage:
+	disvault group -d books --dry-run
+	disvault group -d books --reassign-to uncategorized
+	disvault group -d old-backups --delete-files`,
+	Run: runGroupCmd,
 }
 
 func init() {
 	groupCmd.Flags().StringVarP(&group, "name", "n", "", "Create a new group with the specified name")
 	groupCmd.Flags().StringVarP(&parentGroup, "parent", "p", "", "Specify a parent group by name or ID when creating a new group")
-	groupCmd.Flags().StringVarP(&deleteGroupName, "delete", "d", "", "Delete a group using its group name")
+	groupCmd.Flags().StringVarP(&deleteGroupName, "delete", "d", "", "Delete a group using its group name or ID")
+	groupCmd.Flags().StringVar(&reassignTo, "reassign-to", "", "When deleting, move the files of the group and its child groups to this group")
+	groupCmd.Flags().BoolVar(&deleteGroupFiles, "delete-files", false, "When deleting, also delete the files of the group and its child groups from Discord")
+	groupCmd.Flags().BoolVar(&deleteDryRun, "dry-run", false, "When deleting, only show the groups and files that would be affected")
 	groupCmd.Flags().BoolVarP(&listGroups, "list", "l", false, "List all available groups")
 	groupCmd.MarkFlagsMutuallyExclusive("name", "delete", "list")
+	groupCmd.MarkFlagsMutuallyExclusive("reassign-to", "delete-files")
 
 	rootCmd.AddCommand(groupCmd)
 }
@@ -44,10 +61,17 @@ func runGroupCmd(cmd *cobra.Command, args []string) {
 	app.Init()
 
 	if cmd.Flags().Changed("parent") && !cmd.Flags().Changed("name") {
-		fmt.Println("Error: The -p (parent group) flag can only be used with the -n (name) flag.\nYou may haven't provided a name.")
+		fmt.Fprintln(db.Messages, "Error: The -p (parent group) flag can only be used with the -n (name) flag.\nYou may haven't provided a name.")
 		return
 	}
 
+	for _, name := range []string{"reassign-to", "delete-files", "dry-run"} {
+		if cmd.Flags().Changed(name) && !cmd.Flags().Changed("delete") {
+			fmt.Fprintf(db.Messages, "Error: The --%s flag can only be used with the -d (delete) flag.\n", name)
+			return
+		}
+	}
+
 	switch {
 	case listGroups:
 		listAllGroups()
@@ -56,160 +80,191 @@ func runGroupCmd(cmd *cobra.Command, args []string) {
 	case deleteGroupName != "":
 		deleteGroup(deleteGroupName)
 	default:
-		fmt.Println("Please provide a valid flag. Use -n to create a group or -d to delete a group.")
+		fmt.Fprintln(db.Messages, "Please provide a valid flag. Use -n to create a group or -d to delete a group.")
 	}
 }
 
+// groupRecord is how a group is written in the machine-readable output formats
+type groupRecord struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"` // nil for root groups
+	Parent   string `json:"parent"`
+	Path     string `json:"path"`
+}
+
 func listAllGroups() {
-	// Fetch group details along with their parent group names using a LEFT JOIN
-	rows, err := db.DB.Query(`
-		SELECT g.group_id, g.group_name, pg.group_name AS parent_group_name
-		FROM groups g
-		LEFT JOIN groups pg ON g.parent_group_id = pg.group_id
-	`)
+	all, err := db.Default.ListGroups(context.Background())
 	if err != nil {
 		log.Fatalf("Error fetching groups: %v", err)
 	}
-	defer rows.Close()
+
+	groups := []groupRecord{}
+	for _, g := range all {
+		record := groupRecord{ID: g.ID, Name: g.Name, Parent: g.Parent, Path: g.Path}
+		if g.ParentID != 0 {
+			parentID := g.ParentID
+			record.ParentID = &parentID
+		}
+		groups = append(groups, record)
+	}
+
+	if machineOutput() {
+		if err := render(stdout, groups); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
 
 	// Create a new tabwriter for formatted output
-	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
 
 	// Print the header
 	fmt.Fprintln(writer, "GROUP ID\tGROUP NAME\tPARENT GROUP NAME")
 
-	// Iterate over the rows and print each group
-	for rows.Next() {
-		var groupID int
-		var groupName string
-		var parentGroupName sql.NullString
-
-		// Scan the row into variables
-		if err := rows.Scan(&groupID, &groupName, &parentGroupName); err != nil {
-			log.Fatalf("Error scanning row: %v", err)
-		}
-
-		// Handle NULL values for parent_group_name
+	for _, g := range groups {
+		// Root groups have no parent
 		parentName := "NONE/ROOT"
-		if parentGroupName.Valid {
-			parentName = parentGroupName.String
+		if g.ParentID != nil {
+			parentName = g.Parent
 		}
 
 		// Print the row
-		fmt.Fprintf(writer, "%d\t%s\t%s\n", groupID, groupName, parentName)
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", g.ID, g.Name, parentName)
 	}
 
 	// Flush the writer to ensure the data is written to the output
 	writer.Flush()
-
-	// Check for errors from iterating over rows
-	if err = rows.Err(); err != nil {
-		log.Fatalf("Error iterating over rows: %v", err)
-	}
 }
 
 func createGroup() {
-	var parentID interface{} = nil
+	var parentID int
 
 	if parentGroup != "" {
-		id, err := fetchGroupIDByName(parentGroup)
+		id, err := resolveGroup(parentGroup)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintf(db.Messages, "Error: Parent group '%s' not found\n", parentGroup)
 			return
 		}
 		parentID = id
 	}
 
-	_, err := db.DB.Exec("INSERT INTO groups (group_name, parent_group_id) VALUES (?, ?)", group, parentID)
+	_, err := db.Default.CreateGroup(context.Background(), group, parentID)
 	if err != nil {
-		if isUniqueConstraintError(err) {
-			fmt.Printf("Error: A group with the name '%s' already exists. Please choose a different name.\n", group)
+		if errors.Is(err, db.ErrGroupExists) {
+			fmt.Fprintf(db.Messages, "Error: A group with the name '%s' already exists. Please choose a different name.\n", group)
 		} else {
 			log.Fatalf("Error creating group: %v", err)
 		}
 		return
 	}
+	markDatabaseChanged()
 
-	fmt.Printf("Group '%s' created successfully.\n", group)
+	fmt.Fprintf(db.Messages, "Group '%s' created successfully.\n", group)
 }
 
-func fetchGroupIDByName(name string) (int, error) {
-	var groupID int
-	err := db.DB.QueryRow("SELECT group_id FROM groups WHERE group_name = ?", name).Scan(&groupID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return 0, fmt.Errorf("error: Parent group '%s' not found", name)
-		}
-		log.Fatalf("Error fetching group ID by name: %v", err)
-	}
-	return groupID, nil
+// resolveGroup accepts either a group name or a numeric group ID and returns the group ID.
+// Names are checked first so a group literally named "2024" still resolves by name.
+func resolveGroup(ref string) (int, error) {
+	return db.Default.ResolveGroup(context.Background(), ref)
 }
 
-func deleteGroup(groupName string) {
-	groupID, err := fetchGroupIDByName(groupName)
+// deleteGroup removes a group together with all of its child groups.
+//
+// What happens to the files inside the subtree depends on the flags:
+//   - default: refuse to delete when the subtree still contains files or child groups
+//   - --reassign-to: move the files to another group before deleting
+//   - --delete-files: remove the files and their parts on Discord as well
+//
+// With --dry-run nothing is changed, only the affected groups and files are printed.
+func deleteGroup(groupRef string) {
+	ctx := context.Background()
+
+	groupID, err := resolveGroup(groupRef)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
 		return
 	}
-
-	// Reassign files to the 'uncategorized' group (group_id = 1)
-	_, err = db.DB.Exec("UPDATE files SET group_id = 1 WHERE group_id = ?", groupID)
-	if err != nil {
-		log.Fatalf("Error reassigning files to 'uncategorized': %v", err)
+	if groupID == db.DefaultGroupID {
+		fmt.Fprintln(db.Messages, "Error: The 'uncategorized' group is the default group and can't be deleted.")
+		return
 	}
 
-	// Recursively delete all child groups
-	deleteChildGroups(groupID)
-
-	// Delete the parent group from the database
-	_, err = db.DB.Exec("DELETE FROM groups WHERE group_id = ?", groupID)
+	groups, err := db.Default.GroupTree(ctx, groupID)
 	if err != nil {
-		log.Fatalf("Error deleting group: %v", err)
+		log.Fatalf("Error fetching child groups: %v", err)
 	}
-
-	fmt.Printf("Group '%s' and its child groups deleted successfully, and files reassigned to 'uncategorized'.\n", groupName)
-}
-
-func deleteChildGroups(parentID int) {
-	rows, err := db.DB.Query("SELECT group_id FROM groups WHERE parent_group_id = ?", parentID)
+	files, err := db.Default.ListFiles(ctx, db.FileFilter{GroupID: groupID, Recursive: true, States: db.FileStates, AllVersions: true})
 	if err != nil {
-		log.Fatalf("Error fetching child groups: %v", err)
+		log.Fatalf("Error fetching files of the group: %v", err)
 	}
-	defer rows.Close()
 
-	var childGroupIDs []int
-	for rows.Next() {
-		var childID int
-		if err := rows.Scan(&childID); err != nil {
-			log.Fatalf("Error scanning child group ID: %v", err)
+	var reassignID int
+	if reassignTo != "" {
+		reassignID, err = resolveGroup(reassignTo)
+		if err != nil {
+			fmt.Fprintf(db.Messages, "Error: %v\n", err)
+			return
+		}
+		for _, g := range groups {
+			if g.ID == reassignID {
+				fmt.Fprintf(db.Messages, "Error: Can't reassign files to '%s', it is deleted together with '%s'.\n", g.Name, groups[0].Name)
+				return
+			}
 		}
-		childGroupIDs = append(childGroupIDs, childID)
 	}
 
-	for _, childID := range childGroupIDs {
-		// Recursively delete children of the current child group
-		deleteChildGroups(childID)
+	if deleteDryRun {
+		previewGroupDeletion(groups, files)
+		return
+	}
 
-		// Reassign files to the 'uncategorized' group (group_id = 1)
-		_, err := db.DB.Exec("UPDATE files SET group_id = 1 WHERE group_id = ?", childID)
-		if err != nil {
-			log.Fatalf("Error reassigning files to 'uncategorized' for group %d: %v", childID, err)
-		}
+	if reassignTo == "" && !deleteGroupFiles && (len(files) > 0 || len(groups) > 1) {
+		fmt.Fprintf(db.Messages, "Error: Group '%s' is not empty (%d child group(s), %d file(s)).\n", groups[0].Name, len(groups)-1, len(files))
+		fmt.Fprintln(db.Messages, "Use --reassign-to <group> to keep the files, --delete-files to remove them, or --dry-run to preview.")
+		return
+	}
 
-		// Delete the child group
-		_, err = db.DB.Exec("DELETE FROM groups WHERE group_id = ?", childID)
-		if err != nil {
-			log.Fatalf("Error deleting child group %d: %v", childID, err)
+	// Files on Discord can't be part of the transaction, they are deleted one by one first
+	if deleteGroupFiles {
+		markDatabaseChanged()
+		for _, f := range files {
+			if err := core.DeleteFileParts(f.ID); err != nil {
+				log.Fatalf("Failed to delete file %d (%s): %v", f.ID, f.Name, err)
+			}
 		}
+		fmt.Fprintf(db.Messages, "Deleted %d file(s).\n", len(files))
+	}
 
-		fmt.Printf("Child group with ID '%d' deleted successfully.\n", childID)
+	// Reassigning the files and deleting the groups either happens completely or not at all
+	if err := db.Default.DeleteGroups(ctx, groups, reassignID); err != nil {
+		log.Fatalf("Error deleting group: %v", err)
 	}
+	markDatabaseChanged()
+
+	if reassignTo != "" {
+		fmt.Fprintf(db.Messages, "Reassigned %d file(s) to '%s'.\n", len(files), reassignTo)
+	}
+	for _, g := range groups[1:] {
+		fmt.Fprintf(db.Messages, "Child group '%s' deleted successfully.\n", g.Name)
+	}
+	fmt.Fprintf(db.Messages, "Group '%s' deleted successfully.\n", groups[0].Name)
 }
 
-func isUniqueConstraintError(err error) bool {
-	if err == nil {
-		return false
+// previewGroupDeletion prints what a group deletion would affect without changing anything.
+func previewGroupDeletion(groups []db.GroupNode, files []db.File) {
+	fmt.Fprintf(db.Messages, "Dry run: deleting group '%s' would remove %d group(s):\n", groups[0].Name, len(groups))
+	for _, g := range groups {
+		fmt.Fprintf(db.Messages, "  %s- %s (ID %d)\n", strings.Repeat("  ", g.Depth), g.Name, g.ID)
+	}
+
+	switch {
+	case reassignTo != "":
+		fmt.Fprintf(db.Messages, "\n%d file(s) would be reassigned to '%s':\n", len(files), reassignTo)
+	case deleteGroupFiles:
+		fmt.Fprintf(db.Messages, "\n%d file(s) would be deleted from Discord and the database:\n", len(files))
+	default:
+		fmt.Fprintf(db.Messages, "\n%d file(s) are in these groups:\n", len(files))
 	}
-	sqlErr, ok := err.(interface{ Error() string })
-	return ok && strings.Contains(sqlErr.Error(), "UNIQUE constraint failed")
+	listAllFiles(files)
 }
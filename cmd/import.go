@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the import command
+var (
+	importGroup      string
+	importConflict   string
+	importDryRun     bool
+	importAnyChannel bool
+)
+
+// conflictPolicies maps the values of --on-conflict to the policies of db.Import
+var conflictPolicies = map[string]db.ConflictPolicy{
+	"rename": db.ConflictRename,
+	"skip":   db.ConflictSkip,
+}
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <manifest>",
+	Short: "Merge a manifest written by export into the database",
+	Long: `Import registers the files of a manifest written by the export command, so the files
+uploaded by someone else to the same channel can be listed and downloaded.
+
+Groups are matched by name and created when they don't exist, with --group every file goes into
+that group instead. Files that are already registered are left alone, so importing the same
+manifest again only adds what is new. When the name of a file is taken in its group --on-conflict
+decides what happens: rename imports it as "name (1).ext" and skip leaves it out. An imported file
+never becomes a version of a file that is already registered.
+
+Example usage:
+	disvault import vault.json --dry-run
+	disvault import vault.json --group shared --on-conflict skip`,
+	Args: cobra.ExactArgs(1),
+	Run:  runImportCmd,
+}
+
+func init() {
+	importCmd.Flags().StringVarP(&importGroup, "group", "g", "", "Put every file into this group (name or ID) instead of the groups of the manifest")
+	importCmd.Flags().StringVar(&importConflict, "on-conflict", "rename", "What to do when a file name is taken in its group: rename or skip")
+	importCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "Only show what would be imported")
+	importCmd.Flags().BoolVar(&importAnyChannel, "any-channel", false, "Import a manifest exported from another channel")
+
+	rootCmd.AddCommand(importCmd)
+}
+
+// importRecord is how an imported file is written in the machine-readable output formats
+type importRecord struct {
+	ManifestID int    `json:"manifest_id"`
+	ID         int    `json:"id"` // ID in the database, 0 when not imported
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason"`
+}
+
+func runImportCmd(cmd *cobra.Command, args []string) {
+	policy, ok := conflictPolicies[importConflict]
+	if !ok {
+		fmt.Fprintf(db.Messages, "Error: unknown --on-conflict '%s', use rename or skip.\n", importConflict)
+		cmd.Help()
+		return
+	}
+
+	db.InitDatabase()
+	app.Init()
+	ctx := context.Background()
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatalf("Error reading manifest: %v", err)
+	}
+	var manifest db.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		log.Fatalf("Error: %s is not a JSON manifest: %v", args[0], err)
+	}
+
+	// The parts can only be downloaded from the channel they were uploaded to
+	if manifest.ChannelID != "" && manifest.ChannelID != app.Config.ChannelID && !importAnyChannel {
+		log.Fatalf("Error: the manifest was exported from channel %s but the configured channel is %s, pass --any-channel to import it anyway", manifest.ChannelID, app.Config.ChannelID)
+	}
+
+	opts := db.ImportOptions{OnConflict: policy, DryRun: importDryRun}
+	if importGroup != "" {
+		if opts.GroupID, err = resolveGroup(importGroup); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	result, err := db.Default.Import(ctx, manifest, opts)
+	if err != nil {
+		log.Fatalf("Error importing manifest: %v", err)
+	}
+
+	counts := make(map[string]int)
+	records := make([]importRecord, 0, len(result.Files))
+	for _, f := range result.Files {
+		counts[f.Status]++
+		records = append(records, importRecord{ManifestID: f.ManifestID, ID: f.ID, Name: f.Name, Status: f.Status, Reason: f.Reason})
+	}
+	if !importDryRun && counts[db.ImportAdded]+counts[db.ImportRenamed] > 0 {
+		markDatabaseChanged()
+	}
+
+	if machineOutput() {
+		if err := render(stdout, records); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		printImportSummary(stdout, result, records)
+	}
+
+	fmt.Fprintf(db.Messages, "\n%d imported, %d renamed, %d already in the database, %d skipped, %d invalid.\n",
+		counts[db.ImportAdded], counts[db.ImportRenamed], counts[db.ImportExists], counts[db.ImportSkipped], counts[db.ImportInvalid])
+	if importDryRun {
+		fmt.Fprintln(db.Messages, "Dry run, nothing was changed.")
+	}
+}
+
+// printImportSummary writes the groups created by the import and the files of the manifest as a table
+func printImportSummary(w io.Writer, result db.ImportResult, records []importRecord) {
+	for _, g := range result.Groups {
+		if g.Created {
+			fmt.Fprintf(w, "Created group '%s' (ID %d).\n", g.Name, g.ID)
+		}
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(w, "The manifest has no files.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tSTATUS")
+	for _, r := range records {
+		id := "-"
+		if r.ID != 0 {
+			id = fmt.Sprint(r.ID)
+		}
+		status := r.Status
+		if r.Reason != "" {
+			status += ": " + r.Reason
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", id, r.Name, status)
+	}
+	writer.Flush()
+}
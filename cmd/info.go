@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the info command
+var infoLive bool
+
+// infoCmd represents the info command
+var infoCmd = &cobra.Command{
+	Use:   "info <file_id>",
+	Short: "Show everything stored about a file",
+	Long: `Info prints every attribute stored for a file, its group path, notes, tags and
+each of its parts with the Discord message ID, size and checksum.
+
+With --live every part is also looked up on Discord to check that the message still exists,
+the size of its attachment and when its CDN link expires.
+
+Example usage:
+	disvault info 4
+	disvault info 4 --live --format json`,
+	Args: cobra.ExactArgs(1),
+	Run:  runInfoCmd,
+}
+
+func init() {
+	infoCmd.Flags().BoolVarP(&infoLive, "live", "l", false, "Check every part on Discord")
+
+	rootCmd.AddCommand(infoCmd)
+}
+
+// fileInfo is everything the info command shows about a file
+type fileInfo struct {
+	File  fileRecord   `json:"file"`
+	Notes string       `json:"notes"`
+	Parts []partRecord `json:"parts"`
+}
+
+// partRecord is a single part of a file as shown by the info command
+type partRecord struct {
+	Index      int         `json:"index"`
+	MessageID  string      `json:"message_id"`
+	Size       int64       `json:"size"` // Size in bytes, 0 if not recorded
+	Hash       string      `json:"hash"` // SHA-256 of the chunk, empty if not recorded
+	UploadedAt time.Time   `json:"uploaded_at"`
+	Live       *partStatus `json:"live"` // Only filled with --live
+}
+
+// partStatus is what Discord reports about a part
+type partStatus struct {
+	Exists         bool       `json:"exists"`
+	AttachmentSize int        `json:"attachment_size"`
+	URL            string     `json:"url"`
+	URLExpiresAt   *time.Time `json:"url_expires_at"`
+	Error          string     `json:"error"`
+}
+
+func runInfoCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	fileID, err := ParseFileID(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		cmd.Help()
+		return
+	}
+
+	ctx := context.Background()
+	file, err := db.Default.GetFile(ctx, fileID)
+	if err != nil {
+		log.Fatalf("Error fetching file: %v", err)
+	}
+
+	info := fileInfo{File: newFileRecord(file), Parts: []partRecord{}}
+
+	info.Notes, err = db.Default.Note(ctx, fileID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Fatalf("Error fetching note: %v", err)
+	}
+
+	parts, err := db.Default.FileParts(ctx, fileID)
+	if err != nil {
+		log.Fatalf("Error fetching parts: %v", err)
+	}
+	for _, part := range parts {
+		record := partRecord{
+			Index:      part.Index,
+			MessageID:  part.MessageID,
+			Size:       part.Size,
+			Hash:       part.Hash,
+			UploadedAt: snowflakeTime(part.MessageID),
+		}
+		if infoLive {
+			record.Live = livePartStatus(part.MessageID)
+		}
+		info.Parts = append(info.Parts, record)
+	}
+
+	if machineOutput() {
+		if err := render(stdout, info); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	printFileInfo(stdout, info, file)
+}
+
+// printFileInfo writes the info of a file as a list of attributes followed by a table of its parts
+func printFileInfo(w io.Writer, info fileInfo, file db.File) {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	mode := "-"
+	if info.File.Mode != "" {
+		mode = info.File.Mode + " (" + file.Mode.String() + ")"
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "File ID:\t%d\n", info.File.ID)
+	fmt.Fprintf(writer, "Name:\t%s\n", info.File.Name)
+	fmt.Fprintf(writer, "Size:\t%s (%d bytes)\n", formatBytes(info.File.Size), info.File.Size)
+	fmt.Fprintf(writer, "Parts:\t%d recorded, %d expected\n", len(info.Parts), info.File.Parts)
+	fmt.Fprintf(writer, "SHA-256:\t%s\n", info.File.Hash)
+	fmt.Fprintf(writer, "UUID:\t%s\n", orDash(info.File.UUID))
+	if info.File.State == db.StateDeleting {
+		fmt.Fprintf(writer, "State:\t%s, run `disvault delete %d` to finish deleting it\n", info.File.State, info.File.ID)
+	}
+	if info.File.Current {
+		fmt.Fprintf(writer, "Version:\t%d\n", info.File.Version)
+	} else {
+		fmt.Fprintf(writer, "Version:\t%d, replaced by a newer upload, see `disvault versions %d`\n", info.File.Version, info.File.ID)
+	}
+	fmt.Fprintf(writer, "Group:\t%s\n", orDash(info.File.GroupPath))
+	fmt.Fprintf(writer, "Tags:\t%s\n", orDash(strings.Join(info.File.Tags, ", ")))
+	fmt.Fprintf(writer, "Uploaded:\t%s\n", formatTime(file.UploadedAt))
+	fmt.Fprintf(writer, "Modified:\t%s\n", formatTime(file.ModTime))
+	fmt.Fprintf(writer, "Mode:\t%s\n", mode)
+	fmt.Fprintf(writer, "MIME type:\t%s\n", orDash(info.File.MimeType))
+	fmt.Fprintf(writer, "Original path:\t%s\n", orDash(info.File.OriginalPath))
+	fmt.Fprintf(writer, "Uploader host:\t%s\n", orDash(info.File.UploaderHost))
+	fmt.Fprintf(writer, "Notes:\t%s\n", orDash(info.Notes))
+	writer.Flush()
+
+	if len(info.Parts) == 0 {
+		fmt.Fprintln(w, "\nNo parts recorded for this file.")
+		return
+	}
+
+	fmt.Fprintln(w)
+	writer = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.Debug)
+	header := "PART\tMESSAGE ID\tSIZE\tSHA-256\tUPLOADED"
+	if infoLive {
+		header += "\tON DISCORD\tATTACHMENT SIZE\tURL EXPIRES"
+	}
+	fmt.Fprintln(writer, header)
+
+	for _, part := range info.Parts {
+		size := "-"
+		if part.Size > 0 {
+			size = formatBytes(part.Size)
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s", part.Index, part.MessageID, size, orDash(part.Hash), formatTime(part.UploadedAt))
+
+		if part.Live != nil {
+			switch {
+			case part.Live.Error != "":
+				fmt.Fprintf(writer, "\terror: %s\t-\t-", part.Live.Error)
+			case !part.Live.Exists:
+				fmt.Fprint(writer, "\tMISSING\t-\t-")
+			default:
+				expires := "-"
+				if part.Live.URLExpiresAt != nil {
+					expires = formatTime(*part.Live.URLExpiresAt)
+				}
+				fmt.Fprintf(writer, "\tyes\t%s\t%s", formatBytes(int64(part.Live.AttachmentSize)), expires)
+			}
+		}
+		fmt.Fprintln(writer)
+	}
+	writer.Flush()
+}
+
+// livePartStatus looks up a part on Discord
+func livePartStatus(messageID string) *partStatus {
+	status := &partStatus{}
+
+	attachment, err := app.PartAttachment(messageID)
+	switch {
+	case app.IsUnknownMessage(err):
+		return status
+	case err != nil:
+		status.Error = err.Error()
+		return status
+	}
+
+	status.Exists = true
+	status.AttachmentSize = attachment.Size
+	status.URL = attachment.URL
+	if expiry, ok := app.AttachmentExpiry(attachment.URL); ok {
+		status.URLExpiresAt = &expiry
+	}
+	return status
+}
+
+// snowflakeTime returns the creation time encoded in a Discord ID
+func snowflakeTime(id string) time.Time {
+	t, err := discordgo.SnowflakeTimestamp(id)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
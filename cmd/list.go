@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"regexp"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/AnkanNandi/disvault/app"
 	"github.com/AnkanNandi/disvault/db"
@@ -13,9 +17,23 @@ import (
 
 // required flags
 var (
-	searchText string
-	fileID     int
-	gID        int
+	searchText    string
+	fileID        int
+	listGroup     string
+	listRecursive bool
+	listMinSize   string
+	listMaxSize   string
+	listAfter     string
+	listBefore    string
+	listExts      []string
+	listHash      string
+	listRegex     string
+	listTags      []string
+	listLimit     int
+	listOffset    int
+	listPage      int
+	listSort      string
+	listDesc      bool
 )
 
 // listCmd represents the list command in the file
@@ -24,16 +42,44 @@ var listCmd = &cobra.Command{
 	Short: "List the uploaded files",
 	Long: `List command shows the uploaded files in a minimal table format.
 You may need to use list command to check the ID or group of a file
-for download, delete and updating it.`,
+for download, delete and updating it.
+
+Every given filter has to match for a file to be listed.
+
+Results are shown 50 at a time, browse with --page or --offset and change
+the page size with --limit (0 shows everything).
+
+Example usage:
+	disvault list --sort size --desc
+	disvault list --sort uploaded --page 3
+	disvault list --group finance --recursive --ext pdf --min-size 1MB
+	disvault list --uploaded-after 2024-01-01 --regex '^IMG_[0-9]+'
+	disvault list --group alpha --tag contract --tag signed`,
 	Run: runListCmd,
 }
 
 func init() {
 	listCmd.Flags().StringVarP(&searchText, "search", "s", "", "Search by file name, put the keywords")
 	listCmd.Flags().IntVarP(&fileID, "id", "i", 0, "the exact file ID you may wanna search")
-	listCmd.Flags().IntVarP(&gID, "group", "g", 0, "Search by files by group ID")
+	listCmd.Flags().StringVarP(&listGroup, "group", "g", "", "Search files by group name or ID")
+	listCmd.Flags().BoolVarP(&listRecursive, "recursive", "r", false, "With --group, also match files in its child groups")
+	listCmd.Flags().StringVar(&listMinSize, "min-size", "", "Only files of at least this size, i.e. 500KB, 1.5GB")
+	listCmd.Flags().StringVar(&listMaxSize, "max-size", "", "Only files of at most this size, i.e. 500KB, 1.5GB")
+	listCmd.Flags().StringVar(&listAfter, "uploaded-after", "", "Only files uploaded at or after this date (YYYY-MM-DD)")
+	listCmd.Flags().StringVar(&listBefore, "uploaded-before", "", "Only files uploaded before this date (YYYY-MM-DD)")
+	listCmd.Flags().StringSliceVarP(&listExts, "ext", "e", nil, "Only files with one of these extensions, i.e. --ext pdf,docx")
+	listCmd.Flags().StringVar(&listHash, "hash", "", "Only files whose SHA-256 hash starts with this prefix")
+	listCmd.Flags().StringVar(&listRegex, "regex", "", "Only files whose name matches this regular expression")
+	listCmd.Flags().StringArrayVarP(&listTags, "tag", "t", nil, "Only files with this tag, repeat it to require several tags")
+
+	listCmd.Flags().IntVarP(&listLimit, "limit", "n", 50, "Maximum number of files shown, 0 shows every file")
+	listCmd.Flags().IntVar(&listOffset, "offset", 0, "Skip this many files before listing")
+	listCmd.Flags().IntVarP(&listPage, "page", "p", 0, "Show this page of results, pages are --limit files long")
+	listCmd.Flags().StringVar(&listSort, "sort", "id", "Sort by name, size, id or uploaded")
+	listCmd.Flags().BoolVar(&listDesc, "desc", false, "Sort in descending order")
 
 	listCmd.MarkFlagsMutuallyExclusive("id", "search")
+	listCmd.MarkFlagsMutuallyExclusive("offset", "page")
 
 	rootCmd.AddCommand(listCmd)
 }
@@ -42,18 +88,148 @@ func init() {
 func runListCmd(cmd *cobra.Command, args []string) {
 	db.InitDatabase()
 	app.Init()
-	if cmd.Flags().Changed("group") {
-		ValidateGroupID(gID)
+	if listRecursive && listGroup == "" {
+		fmt.Fprintln(db.Messages, "Error: The --recursive flag can only be used with the --group flag.")
+		return
 	}
-	filesList, err := fetchFiles(searchText, fileID, gID)
+
+	filter, err := listFilters()
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+
+	if listLimit < 0 || listOffset < 0 || listPage < 0 {
+		fmt.Fprintln(db.Messages, "Error: --limit, --offset and --page can't be negative.")
+		return
+	}
+
+	offset := listOffset
+	if listPage > 0 {
+		if listLimit == 0 {
+			fmt.Fprintln(db.Messages, "Error: --page needs a --limit bigger than 0.")
+			return
+		}
+		offset = (listPage - 1) * listLimit
+	}
+
+	filter.Sort = listSort
+	filter.Desc = listDesc
+	filter.Limit = listLimit
+	filter.Offset = offset
+
+	ctx := context.Background()
+	filesList, err := db.Default.ListFiles(ctx, filter)
 	if err != nil {
 		log.Fatalf("error while fetching files: %v", err)
 	}
-	listAllFiles(filesList)
+	// Count every match so the footer can tell how many files are left out
+	total, err := db.Default.CountFiles(ctx, filter)
+	if err != nil {
+		log.Fatalf("error while counting files: %v", err)
+	}
+	if machineOutput() {
+		if err := render(stdout, fileRecords(filesList)); err != nil {
+			log.Fatalf("error while writing output: %v", err)
+		}
+		// The footer goes to stderr in machine-readable formats
+		printListFooter(stdout, len(filesList), offset, total)
+		return
+	}
+	if len(filesList) > 0 || total == 0 {
+		listAllFiles(filesList)
+	}
+	printListFooter(stdout, len(filesList), offset, total)
+}
+
+// listFilters turns the filter flags of the list command into a db.FileFilter
+func listFilters() (db.FileFilter, error) {
+	filter := db.FileFilter{
+		Search:     searchText,
+		Recursive:  listRecursive,
+		HashPrefix: strings.ToLower(listHash),
+		Regex:      listRegex,
+	}
+	if fileID != 0 {
+		filter.IDs = []int{fileID}
+	}
+
+	if listGroup != "" {
+		groupID, err := resolveGroup(listGroup)
+		if err != nil {
+			return filter, err
+		}
+		filter.GroupID = groupID
+	}
+
+	if listMinSize != "" {
+		size, err := parseSize(listMinSize)
+		if err != nil {
+			return filter, err
+		}
+		filter.MinSize = &size
+	}
+	if listMaxSize != "" {
+		size, err := parseSize(listMaxSize)
+		if err != nil {
+			return filter, err
+		}
+		filter.MaxSize = &size
+	}
+
+	if listAfter != "" {
+		t, err := parseDate(listAfter)
+		if err != nil {
+			return filter, err
+		}
+		filter.UploadedAfter = t
+	}
+	if listBefore != "" {
+		t, err := parseDate(listBefore)
+		if err != nil {
+			return filter, err
+		}
+		filter.UploadedBefore = t
+	}
+
+	for _, ext := range listExts {
+		ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+		if ext != "" {
+			filter.Exts = append(filter.Exts, ext)
+		}
+	}
+
+	if len(listTags) > 0 {
+		tags, err := normalizeTags(listTags)
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = tags
+	}
+
+	if listRegex != "" {
+		if _, err := regexp.Compile(listRegex); err != nil {
+			return filter, fmt.Errorf("invalid regular expression: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// printListFooter tells which part of the matching files was shown
+func printListFooter(w io.Writer, shown, offset, total int) {
+	if total == 0 {
+		return
+	}
+	if shown == 0 {
+		fmt.Fprintf(w, "\nNo files on this page, %d file(s) matched in total.\n", total)
+		return
+	}
+	fmt.Fprintf(w, "\nShowing %d-%d of %d file(s).\n", offset+1, offset+shown, total)
 }
 
 // formatBytes converts bytes to a human-readable string with appropriate units (B, KB, MB, GB).
-func formatBytes(bytes int) string {
+func formatBytes(bytes int64) string {
 	const (
 		kb = 1024
 		mb = kb * 1024
@@ -72,22 +248,100 @@ func formatBytes(bytes int) string {
 	}
 }
 
-type listFile struct {
-	id        int
-	name      string
-	size      int // Size in bytes
-	parts     int
-	groupName string
+// formatTime formats a time for the tables, unknown times are shown as -
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
 }
 
-// listAllFiles displays a formatted list of files in a tabular format using the provided slice of listFile structs.
+// shortMimeType drops the parameters of a MIME type, i.e. "; charset=utf-8", to keep the tables narrow
+func shortMimeType(mimeType string) string {
+	if mimeType == "" {
+		return "-"
+	}
+	return strings.TrimSpace(strings.Split(mimeType, ";")[0])
+}
+
+// fileRecord is how a file is written in the machine-readable output formats,
+// the field names are part of the output and must stay stable
+type fileRecord struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"` // Size in bytes
+	Parts     int      `json:"parts"`
+	Hash      string   `json:"hash"`
+	UUID      string   `json:"uuid"`
+	State     string   `json:"state"`
+	Version   int      `json:"version"`
+	Current   bool     `json:"current"` // False for versions replaced by a newer upload
+	Group     string   `json:"group"`
+	GroupPath string   `json:"group_path"`
+	Tags      []string `json:"tags"`
+
+	UploadedAt   *time.Time `json:"uploaded_at"`
+	ModifiedAt   *time.Time `json:"modified_at"`
+	Mode         string     `json:"mode"` // Octal permission bits, i.e. 0644
+	MimeType     string     `json:"mime_type"`
+	OriginalPath string     `json:"original_path"`
+	UploaderHost string     `json:"uploader_host"`
+	DeletedAt    *time.Time `json:"deleted_at"` // Only set for files in the trash
+}
+
+// newFileRecord converts a file to its machine-readable form
+func newFileRecord(f db.File) fileRecord {
+	record := fileRecord{
+		ID:        f.ID,
+		Name:      f.Name,
+		Size:      f.Size,
+		Parts:     f.Total_parts,
+		Hash:      f.Hash,
+		UUID:      f.UUID,
+		State:     f.State,
+		Version:   f.Version,
+		Current:   !f.Replaced,
+		Group:     f.GroupName,
+		GroupPath: f.GroupPath,
+		Tags:      f.Tags,
+
+		MimeType:     f.MimeType,
+		OriginalPath: f.OriginalPath,
+		UploaderHost: f.Host,
+	}
+	if record.Tags == nil {
+		record.Tags = []string{}
+	}
+	if !f.UploadedAt.IsZero() {
+		record.UploadedAt = &f.UploadedAt
+	}
+	if !f.DeletedAt.IsZero() {
+		record.DeletedAt = &f.DeletedAt
+	}
+	if !f.ModTime.IsZero() {
+		record.ModifiedAt = &f.ModTime
+		record.Mode = fmt.Sprintf("%04o", uint32(f.Mode))
+	}
+	return record
+}
+
+// fileRecords converts files to their machine-readable form
+func fileRecords(files []db.File) []fileRecord {
+	records := make([]fileRecord, 0, len(files))
+	for _, f := range files {
+		records = append(records, newFileRecord(f))
+	}
+	return records
+}
+
+// listAllFiles displays a formatted list of files in a tabular format using the provided slice of db.File structs.
 // If the slice is empty, it prints a message indicating that no files matched the criteria.
 //
 // This function is intended to run when no specific flags (such as search, id, or group) are provided by the user,
 // displaying all available files up to the query limit.
 //
 // Parameters:
-//   - files: A slice of listFile structs containing details about each file, including file ID, name, size, total parts, and group name.
+//   - files: A slice of db.File structs containing details about each file, including file ID, name, size, total parts, and group name.
 //
 // Behavior:
 //   - Prints the files in a tabular format with headers for File ID, File Name, File Size, Total Parts, and File Group.
@@ -97,101 +351,26 @@ type listFile struct {
 //
 //	listAllFiles(files)
 //	This will output the list of files in a nicely formatted table or a message if the list is empty.
-func listAllFiles(files []listFile) {
+func listAllFiles(files []db.File) {
 	// Check if the files slice is empty
 	if len(files) == 0 {
-		fmt.Println("No files matched your criteria.")
+		fmt.Fprintln(db.Messages, "No files matched your criteria.")
 		return
 	}
 
 	// Create a new tabwriter for formatted output
-	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
 
 	// Print the header
-	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tFILE SIZE\tTOTAL PARTS\tFILE GROUP")
+	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tFILE SIZE\tTOTAL PARTS\tFILE GROUP\tUPLOADED\tTYPE\tTAGS")
 
 	// Print the data rows
 	for _, file := range files {
-		fmt.Fprintf(writer, "%d\t%s\t%s\t%d\t%s\n", file.id, file.name, formatBytes(file.size), file.parts, file.groupName)
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			file.ID, file.Name, formatBytes(file.Size), file.Total_parts, file.GroupName,
+			formatTime(file.UploadedAt), shortMimeType(file.MimeType), strings.Join(file.Tags, ", "))
 	}
 
 	// Flush the writer to ensure the data is written to the output
 	writer.Flush()
 }
-
-// fetchFiles retrieves a list of files from the database based on the specified search criteria.
-// It supports filtering by file name (using a search pattern), file ID, and group ID.
-// The results are limited to a maximum of 50 files.
-//
-// Parameters:
-//   - search: A string pattern to match against file names using SQL LIKE syntax. If empty, no name filtering is applied.
-//   - id: An integer representing the file ID to search for. If 0, this filter is ignored.
-//   - group: An integer representing the group ID to filter files by. If 0, this filter is ignored.
-//
-// Returns:
-//   - A slice of listFile structs containing the matched files' details, including file ID, name, size, total parts, and group name.
-//   - An error if there was an issue executing the query or scanning the results.
-//
-// Example:
-//
-//	files, err := fetchFiles("report", 0, 2)
-//	This call fetches files whose names contain "report" and belong to group ID 2, limited to 50 entries.
-//
-// Potential Errors:
-//   - Returns an error if the database query fails or if there is an issue scanning the rows.
-//   - Specific errors might include database connection issues, syntax errors in the SQL query, or type mismatches during row scanning.
-func fetchFiles(search string, id int, group int) ([]listFile, error) {
-	// Base query to select files
-	query := `
-		SELECT f.id, f.name, f.size, f.total_parts, g.group_name
-		FROM files f
-		LEFT JOIN groups g ON f.group_id = g.group_id
-		WHERE 1=1
-	`
-	// Parameters slice for query arguments
-	var params []interface{}
-
-	// Conditional query building based on provided flags
-	if search != "" {
-		query += " AND f.name LIKE ?"
-		params = append(params, "%"+search+"%")
-	}
-	if id != 0 {
-		query += " AND f.id = ?"
-		params = append(params, id)
-	}
-	if group != 0 {
-		query += " AND g.group_id = ?"
-		params = append(params, group)
-	}
-
-	// Limit the results to 50 entries
-	query += " LIMIT 50"
-
-	// Execute the query with parameters
-	rows, err := db.DB.Query(query, params...)
-	if err != nil {
-		return nil, fmt.Errorf("error querying files: %w", err)
-	}
-	defer rows.Close()
-
-	// Slice to hold the results
-	var files []listFile
-
-	// Iterate over the rows
-	for rows.Next() {
-		var file listFile
-		err := rows.Scan(&file.id, &file.name, &file.size, &file.parts, &file.groupName)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-		files = append(files, file)
-	}
-
-	// Check for errors from iterating over rows
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over rows: %w", err)
-	}
-
-	return files, nil
-}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the mv command
+var (
+	mvGroup  string
+	mvSearch string
+	mvFrom   string
+)
+
+// mvCmd represents the mv command
+var mvCmd = &cobra.Command{
+	Use:   "mv [file_id...] --group <group>",
+	Short: "Move files to another group",
+	Long: `Move one or more files to another group. Only the database is updated,
+the parts stored on Discord don't know about groups so nothing is re-uploaded.
+
+Files can be picked by their IDs, or in bulk with the --search and --from filters.
+Groups may be given by name or ID.
+
+Example usage:
+	disvault mv 3 7 --group books
+	disvault mv --search invoice --from uncategorized --group finance`,
+	Run: runMvCmd,
+}
+
+func init() {
+	mvCmd.Flags().StringVarP(&mvGroup, "group", "g", "", "Destination group name or ID (required)")
+	mvCmd.Flags().StringVarP(&mvSearch, "search", "s", "", "Move every file whose name contains the keywords")
+	mvCmd.Flags().StringVarP(&mvFrom, "from", "f", "", "Move every file currently in this group (name or ID)")
+	mvCmd.MarkFlagRequired("group")
+
+	rootCmd.AddCommand(mvCmd)
+}
+
+func runMvCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	if len(args) == 0 && mvSearch == "" && mvFrom == "" {
+		fmt.Fprintln(db.Messages, "Error: Provide file IDs or at least one of --search/--from to select files.")
+		cmd.Help()
+		return
+	}
+
+	targetID, err := resolveGroup(mvGroup)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// The files are picked by the given IDs and filters, all of them have to match
+	var filter db.FileFilter
+	for _, arg := range args {
+		id, err := ParseFileID(arg)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		filter.IDs = append(filter.IDs, id)
+	}
+	filter.Search = mvSearch
+	if mvFrom != "" {
+		fromID, err := resolveGroup(mvFrom)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		filter.GroupID = fromID
+	}
+
+	ctx := context.Background()
+	moved, err := db.Default.MoveFiles(ctx, targetID, filter)
+	if err != nil {
+		log.Fatalf("Error moving files: %v", err)
+	}
+	if moved > 0 {
+		markDatabaseChanged()
+	}
+
+	if moved == 0 {
+		fmt.Fprintln(db.Messages, "No files matched your criteria.")
+		return
+	}
+	targetName, err := db.Default.GroupName(ctx, targetID)
+	if err != nil {
+		log.Fatalf("Error fetching group name: %v", err)
+	}
+	fmt.Fprintf(db.Messages, "Moved %d file(s) to group '%s'.\n", moved, targetName)
+}
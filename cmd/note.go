@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the note command
+var clearNote bool
+
+// noteCmd represents the note command
+var noteCmd = &cobra.Command{
+	Use:   "note <file_id> [text]",
+	Short: "Show or set the notes of a file",
+	Long: `Note attaches a free text description to a file, notes are searched by the search command.
+Without a text the current note is shown, setting a new note replaces the old one.
+
+Example usage:
+	disvault note 4 "Signed contract, scanned copy"
+	disvault note 4
+	disvault note 4 --clear`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runNoteCmd,
+}
+
+func init() {
+	noteCmd.Flags().BoolVar(&clearNote, "clear", false, "Remove the note of the file")
+
+	rootCmd.AddCommand(noteCmd)
+}
+
+func runNoteCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	fileID, err := ParseFileID(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		cmd.Help()
+		return
+	}
+
+	fileName, err := FetchFileNameByID(fileID)
+	if err != nil {
+		log.Fatalf("Error fetching file: %v", err)
+	}
+
+	ctx := context.Background()
+	note := strings.TrimSpace(strings.Join(args[1:], " "))
+	switch {
+	case clearNote:
+		if err := db.Default.ClearNote(ctx, fileID); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		markDatabaseChanged()
+		fmt.Fprintf(db.Messages, "Note of '%s' removed.\n", fileName)
+	case note != "":
+		if err := db.Default.SetNote(ctx, fileID, note); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		markDatabaseChanged()
+		fmt.Fprintf(db.Messages, "Note of '%s' saved.\n", fileName)
+	default:
+		current, err := db.Default.Note(ctx, fileID)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			fmt.Fprintf(db.Messages, "'%s' has no note.\n", fileName)
+		case err != nil:
+			log.Fatalf("Error: %v", err)
+		default:
+			fmt.Fprintln(db.Messages, current)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the prune command
+var (
+	pruneKeep     int
+	pruneKeepDays int
+	pruneDryRun   bool
+	pruneYes      bool
+	pruneWorkers  int
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune [file_id...]",
+	Short: "Delete the older versions of files according to the retention policy",
+	Long: `Prune permanently deletes the older versions of files, of every file unless IDs are given.
+A version is kept while it is among the newest --keep versions of its file or was replaced less
+than --keep-days ago, with both rules a version has to fail both to be pruned. The current
+version of a file and versions recorded in a snapshot of the backup command are never pruned,
+delete the snapshot first to let them go.
+
+The rules default to versions_keep and versions_keep_days in data/config.json, set them with
+setup --versions-keep and --versions-keep-days.
+
+Example usage:
+	disvault prune --keep 5
+	disvault prune 12 --keep 1 --dry-run
+	disvault prune --keep 3 --keep-days 90 --yes`,
+	Run: runPruneCmd,
+}
+
+func init() {
+	pruneCmd.Flags().IntVar(&pruneKeep, "keep", 0, "Number of versions kept of every file, the current one included")
+	pruneCmd.Flags().IntVar(&pruneKeepDays, "keep-days", 0, "Days a version is kept after it was replaced")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Show the versions that would be pruned without deleting them")
+	pruneCmd.Flags().BoolVarP(&pruneYes, "yes", "y", false, "Don't ask for confirmation")
+	pruneCmd.Flags().IntVarP(&pruneWorkers, "workers", "w", core.DefaultWorkers, "Number of versions deleted at the same time")
+
+	rootCmd.AddCommand(pruneCmd)
+}
+
+func runPruneCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	keep, keepDays := app.Config.VersionsKeep, app.Config.VersionsKeepDays
+	if cmd.Flags().Changed("keep") {
+		keep = pruneKeep
+	}
+	if cmd.Flags().Changed("keep-days") {
+		keepDays = pruneKeepDays
+	}
+	if keep <= 0 && keepDays <= 0 {
+		fmt.Fprintln(db.Messages, "Error: No retention policy, give --keep or --keep-days or configure them with setup.")
+		return
+	}
+
+	ids, err := ParseFileIDs(args)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		cmd.Help()
+		return
+	}
+	// Any version ID picks the whole file
+	files, err := selectFiles(fileSelector{ids: ids, all: true, versions: true})
+	if err == nil {
+		files, err = withVersions(files)
+	}
+	if err != nil {
+		log.Fatalf("Error fetching files: %v", err)
+	}
+
+	snapshotted, err := db.Default.SnapshotFileIDs(context.Background())
+	if err != nil {
+		log.Fatalf("Error fetching snapshots: %v", err)
+	}
+	pruned := prunableVersions(files, keep, keepDays, snapshotted)
+	if machineOutput() && pruneDryRun {
+		if err := render(stdout, fileRecords(pruned)); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	if len(pruned) == 0 {
+		fmt.Fprintln(db.Messages, "No versions to prune.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tVERSION\tFILE SIZE\tFILE GROUP\tUPLOADED")
+	for _, f := range pruned {
+		fmt.Fprintf(writer, "%d\t%s\t%d\t%s\t%s\t%s\n",
+			f.ID, f.Name, f.Version, formatBytes(f.Size), f.GroupName, formatTime(f.UploadedAt))
+	}
+	writer.Flush()
+
+	fmt.Fprintf(db.Messages, "\n%d version(s), %s in total will be deleted permanently.\n", len(pruned), formatBytes(totalSize(pruned)))
+	if pruneDryRun {
+		fmt.Fprintln(db.Messages, "Dry run, nothing was deleted.")
+		return
+	}
+	if !pruneYes && !confirm("Do you want to continue?") {
+		fmt.Fprintln(db.Messages, "Aborted, nothing was deleted.")
+		return
+	}
+	markTrashPurgeDue()
+
+	results, failed := deletePermanently(pruned, pruneWorkers)
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		fmt.Fprintf(db.Messages, "Pruned %d version(s), %d failed.\n", len(pruned)-failed, failed)
+	}
+	if failed > 0 {
+		fmt.Fprintln(db.Messages, "The failed versions are pending, run `disvault delete --pending` to finish deleting them.")
+		autoBackup()
+		os.Exit(1)
+	}
+}
+
+// prunableVersions returns the active versions that neither keep rule protects, a rule of 0 is off,
+// and that aren't in snapshotted. The age of a version counts from the upload of the version that replaced it.
+func prunableVersions(files []db.File, keep, keepDays int, snapshotted map[int]bool) []db.File {
+	lineages := make(map[int][]db.File)
+	for _, f := range files {
+		lineages[f.LineageID] = append(lineages[f.LineageID], f)
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	var pruned []db.File
+	for _, versions := range lineages {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+		for i, v := range versions {
+			if !v.Replaced || v.State != db.StateActive || snapshotted[v.ID] {
+				continue
+			}
+			kept := keep > 0 && i < keep
+			recent := keepDays > 0 && i > 0 && versions[i-1].UploadedAt.After(cutoff)
+			if !kept && !recent {
+				pruned = append(pruned, v)
+			}
+		}
+	}
+	sort.Slice(pruned, func(i, j int) bool { return pruned[i].ID < pruned[j].ID })
+	return pruned
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the rebuild command
+var (
+	rebuildGroup  string
+	rebuildDryRun bool
+)
+
+// rebuildCmd represents the rebuild command
+var rebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Recover the database from the part messages in the channel",
+	Long: `Rebuild reads the whole history of the channel and registers every file whose parts
+are all there but that is missing from the database, i.e. after data/db.sql was lost.
+
+Every part message carries a header with the file it belongs to, its position, the checksums
+and the file name, encrypted when an encryption key is configured. Groups, tags, notes and the
+other metadata aren't part of the messages, recovered files are put into --group. A recovered file
+whose name is taken there is numbered like "name (1).ext", it never becomes a version of another file.
+Files uploaded before the headers existed can't be recovered this way.
+
+Example usage:
+	disvault rebuild --dry-run
+	disvault rebuild --group recovered`,
+	Args: cobra.NoArgs,
+	Run:  runRebuildCmd,
+}
+
+func init() {
+	rebuildCmd.Flags().StringVarP(&rebuildGroup, "group", "g", "uncategorized", "Group the recovered files are put into (name or ID)")
+	rebuildCmd.Flags().BoolVar(&rebuildDryRun, "dry-run", false, "Only show what would be recovered")
+
+	rootCmd.AddCommand(rebuildCmd)
+}
+
+// Status values of a rebuildRecord
+const (
+	rebuildRecovered  = "recovered"
+	rebuildKnown      = "known"
+	rebuildIncomplete = "incomplete"
+	rebuildFailed     = "failed"
+)
+
+// rebuildRecord is a file found in the channel and what rebuild did with it
+type rebuildRecord struct {
+	UUID   string `json:"uuid"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"` // Size in bytes
+	Parts  int    `json:"parts"`
+	Found  int    `json:"found"` // Parts found in the channel
+	Status string `json:"status"`
+	FileID int    `json:"file_id"` // ID in the database, 0 if not registered
+	Error  string `json:"error"`
+}
+
+// foundFile collects the part messages of a file while the channel is scanned
+type foundFile struct {
+	header     core.PartHeader // Header of the first part found
+	parts      map[int]db.Part // By index
+	uploadedAt time.Time       // Time of the oldest part
+}
+
+func runRebuildCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+	ctx := context.Background()
+
+	groupID, err := resolveGroup(rebuildGroup)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	knownParts, err := db.Default.KnownPartIDs(ctx)
+	if err != nil {
+		log.Fatalf("Error fetching parts: %v", err)
+	}
+	knownFiles, err := db.Default.FileIDsByUUID(ctx)
+	if err != nil {
+		log.Fatalf("Error fetching files: %v", err)
+	}
+
+	found := make(map[string]*foundFile)
+	scanned, withoutHeader, invalid := 0, 0, 0
+	err = app.ScanChannel(func(messages []*discordgo.Message) error {
+		for _, m := range messages {
+			scanned++
+			header, ok, err := core.ParseHeader(m.Content)
+			switch {
+			case !ok:
+				if len(m.Attachments) > 0 && !knownParts[m.ID] && !core.IsBackupMessage(m.Content) {
+					withoutHeader++
+				}
+				continue
+			case err != nil:
+				invalid++
+				fmt.Fprintf(db.Messages, "Warning: message %s: %v\n", m.ID, err)
+				continue
+			}
+
+			file := found[header.FileUUID]
+			if file == nil {
+				file = &foundFile{header: header, parts: make(map[int]db.Part)}
+				found[header.FileUUID] = file
+			}
+			// A part uploaded twice keeps its oldest message, the history is read newest first
+			file.parts[header.Index] = db.Part{MessageID: m.ID, Index: header.Index, Size: header.Size, Hash: header.Hash}
+			if t := snowflakeTime(m.ID); file.uploadedAt.IsZero() || t.Before(file.uploadedAt) {
+				file.uploadedAt = t
+			}
+		}
+		fmt.Fprintf(db.Messages, "Scanned %d message(s), found %d file(s)...\n", scanned, len(found))
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error reading the channel: %v", err)
+	}
+
+	// Register the files in upload order so their IDs follow the original order
+	files := make([]*foundFile, 0, len(found))
+	for _, f := range found {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].uploadedAt.Before(files[j].uploadedAt) })
+
+	records := make([]rebuildRecord, 0, len(files))
+	for _, f := range files {
+		records = append(records, rebuildFile(ctx, f, knownFiles, groupID))
+	}
+
+	if machineOutput() {
+		if err := render(stdout, records); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		printRebuildSummary(stdout, records)
+	}
+
+	if withoutHeader > 0 {
+		fmt.Fprintf(db.Messages, "%d message(s) with attachments have no header and aren't in the database, they were uploaded by an older version or by someone else.\n", withoutHeader)
+	}
+	if invalid > 0 {
+		fmt.Fprintf(db.Messages, "%d message(s) have a header that couldn't be read.\n", invalid)
+	}
+	if rebuildDryRun {
+		fmt.Fprintln(db.Messages, "Dry run, nothing was changed.")
+	}
+}
+
+// rebuildFile registers a file found in the channel unless it is known or some of its parts are missing
+func rebuildFile(ctx context.Context, f *foundFile, knownFiles map[string]int, groupID int) rebuildRecord {
+	record := rebuildRecord{
+		UUID:  f.header.FileUUID,
+		Size:  f.header.FileSize,
+		Parts: f.header.Total,
+		Found: len(f.parts),
+	}
+
+	// Anyone who can post in the channel controls the header, a name that would be saved
+	// outside of the download directory is replaced like one that can't be read
+	name, err := f.header.FileName(app.Config.EncryptionKey)
+	switch {
+	case err != nil:
+		name = "recovered-" + f.header.FileUUID
+		record.Error = err.Error()
+	case !db.ValidFileName(name):
+		record.Error = fmt.Sprintf("unsafe file name %q", name)
+		name = "recovered-" + f.header.FileUUID
+	}
+	record.Name = name
+
+	if id, ok := knownFiles[f.header.FileUUID]; ok {
+		record.Status = rebuildKnown
+		record.FileID = id
+		return record
+	}
+
+	parts := db.Parts{}
+	for i := 0; i < f.header.Total; i++ {
+		part, ok := f.parts[i]
+		if !ok {
+			record.Status = rebuildIncomplete
+			return record
+		}
+		parts.Parts = append(parts.Parts, part)
+	}
+
+	record.Status = rebuildRecovered
+	if rebuildDryRun {
+		return record
+	}
+
+	file := &db.FilesLocal{
+		Name:        name,
+		Total_parts: f.header.Total,
+		Size:        f.header.FileSize,
+		Hash:        f.header.FileHash,
+		GroupID:     groupID,
+		UUID:        f.header.FileUUID,
+		UploadedAt:  f.uploadedAt,
+	}
+	fileID, err := db.Default.RegisterRecoveredFile(ctx, file, &parts)
+	if err != nil {
+		record.Status = rebuildFailed
+		record.Error = err.Error()
+		return record
+	}
+	record.FileID, record.Name = int(fileID), file.Name
+	markDatabaseChanged()
+	return record
+}
+
+// printRebuildSummary writes the files found in the channel as a table
+func printRebuildSummary(w io.Writer, records []rebuildRecord) {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No files with part headers were found in the channel.")
+		return
+	}
+
+	counts := make(map[string]int)
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tFILE SIZE\tPARTS\tSTATUS\tUUID")
+	for _, r := range records {
+		counts[r.Status]++
+		id := "-"
+		if r.FileID != 0 {
+			id = fmt.Sprint(r.FileID)
+		}
+		status := r.Status
+		if r.Error != "" {
+			status += ": " + r.Error
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d/%d\t%s\t%s\n", id, r.Name, formatBytes(r.Size), r.Found, r.Parts, status, r.UUID)
+	}
+	writer.Flush()
+
+	fmt.Fprintf(w, "\n%d recovered, %d already in the database, %d incomplete, %d failed.\n",
+		counts[rebuildRecovered], counts[rebuildKnown], counts[rebuildIncomplete], counts[rebuildFailed])
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// renameCmd represents the rename command
+var renameCmd = &cobra.Command{
+	Use:   "rename <file_id> <new_name>",
+	Short: "Rename an uploaded file",
+	Long: `Rename changes the name a file is listed and downloaded with.
+Only the database entry is updated, the parts on Discord are left untouched.
+
+Example usage:
+	disvault rename 4 "report-2024.pdf"`,
+	Args: cobra.ExactArgs(2),
+	Run:  runRenameCmd,
+}
+
+func init() {
+	rootCmd.AddCommand(renameCmd)
+}
+
+func runRenameCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	fileID, err := ParseFileID(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		cmd.Help()
+		return
+	}
+
+	newName := strings.TrimSpace(args[1])
+	if newName == "" || strings.ContainsAny(newName, `/\`) {
+		log.Fatalf("Error: '%s' is not a valid file name", args[1])
+	}
+
+	oldName, err := FetchFileNameByID(fileID)
+	if err != nil {
+		log.Fatalf("Error fetching file: %v", err)
+	}
+
+	if err := db.Default.RenameFile(context.Background(), fileID, newName); err != nil {
+		log.Fatalf("Error renaming file: %v", err)
+	}
+	markDatabaseChanged()
+
+	fmt.Fprintf(db.Messages, "File '%s' renamed to '%s'.\n", oldName, newName)
+}
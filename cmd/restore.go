@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the restore command
+var (
+	snapshotRestoreTo        string
+	snapshotRestorePaths     []string
+	snapshotRestoreOverwrite bool
+	snapshotRestoreSkip      bool
+	snapshotRestorePreserve  bool
+	snapshotRestoreWorkers   int
+)
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <snapshot>",
+	Short: "Download the files of a snapshot into a directory",
+	Long: `Restore downloads every file of a snapshot recorded by the backup command, in the version it
+had when the snapshot was taken, into the directory given with --to. The files keep their paths
+relative to the backed up directory, --path restores only a file or a subdirectory.
+
+Existing files are never replaced unless --overwrite is given, --skip leaves them alone. Files
+whose version was deleted from the vault since can't be restored and are reported as failed.
+
+Example usage:
+	disvault restore 7 --to /tmp/documents
+	disvault restore before-cleanup --to ~/Documents --path taxes/2024 --overwrite --preserve`,
+	Args: cobra.ExactArgs(1),
+	Run:  runRestoreCmd,
+}
+
+func init() {
+	restoreCmd.Flags().StringVarP(&snapshotRestoreTo, "to", "t", "", "Directory the files are restored into (required)")
+	restoreCmd.Flags().StringSliceVarP(&snapshotRestorePaths, "path", "p", nil, "Only restore this file or directory of the snapshot, may be repeated")
+	restoreCmd.Flags().BoolVar(&snapshotRestoreOverwrite, "overwrite", false, "Replace files that already exist")
+	restoreCmd.Flags().BoolVar(&snapshotRestoreSkip, "skip", false, "Skip files that already exist")
+	restoreCmd.Flags().BoolVar(&snapshotRestorePreserve, "preserve", false, "Restore the modification time and permissions the files had when they were uploaded")
+	restoreCmd.Flags().IntVarP(&snapshotRestoreWorkers, "workers", "w", core.DefaultWorkers, "Number of files downloaded at the same time")
+	restoreCmd.MarkFlagRequired("to")
+	restoreCmd.MarkFlagsMutuallyExclusive("overwrite", "skip")
+
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runRestoreCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	snapshot, err := resolveSnapshot(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	files, err := db.Default.SnapshotFiles(context.Background(), snapshot.ID)
+	if err != nil {
+		log.Fatalf("Error fetching snapshot files: %v", err)
+	}
+	files = filterSnapshotPaths(files, snapshotRestorePaths)
+	if len(files) == 0 {
+		fmt.Fprintln(db.Messages, "No files of the snapshot matched your criteria.")
+		return
+	}
+
+	opts := core.DownloadOptions{Policy: core.FailIfExists, Preserve: snapshotRestorePreserve}
+	switch {
+	case snapshotRestoreOverwrite:
+		opts.Policy = core.Overwrite
+	case snapshotRestoreSkip:
+		opts.Policy = core.Skip
+	}
+
+	fmt.Fprintf(db.Messages, "Restoring %d file(s) of snapshot %d into %s\n", len(files), snapshot.ID, snapshotRestoreTo)
+	savedPaths := make([]string, len(files))
+	jobs := make([]core.Job, len(files))
+	for i, f := range files {
+		jobs[i] = func() error {
+			rel := filepath.FromSlash(f.Path)
+			switch {
+			case f.FileID == 0 || f.State == db.StateDeleting:
+				return errors.New("the version was deleted from the vault")
+			case !filepath.IsLocal(rel):
+				return fmt.Errorf("refusing to write outside of %s", snapshotRestoreTo)
+			}
+			path, err := core.DownloadAndReassembleFile(f.FileID, filepath.Join(snapshotRestoreTo, rel), opts)
+			savedPaths[i] = path
+			return err
+		}
+	}
+	errs := core.NewExecutor(snapshotRestoreWorkers).Run(jobs)
+
+	failed, skipped := 0, 0
+	results := make([]fileResult, len(files))
+	for i, err := range errs {
+		results[i] = fileResult{ID: files[i].FileID, Name: files[i].Path, Size: files[i].Size, Status: statusOK, Path: savedPaths[i]}
+		switch {
+		case errors.Is(err, core.ErrSkipped):
+			skipped++
+			results[i].Status = statusSkipped
+			results[i].Error = err.Error()
+		case err != nil:
+			failed++
+			results[i].Status = statusFailed
+			results[i].Error = err.Error()
+		}
+	}
+
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Failed to write output: %v", err)
+		}
+	} else {
+		fmt.Fprintln(db.Messages, "\nRestore summary:")
+		for _, r := range results {
+			switch r.Status {
+			case statusSkipped:
+				fmt.Fprintf(db.Messages, "  SKIPPED %s: %s\n", r.Name, r.Error)
+			case statusFailed:
+				fmt.Fprintf(db.Messages, "  FAILED  %s: %s\n", r.Name, r.Error)
+			default:
+				fmt.Fprintf(db.Messages, "  OK      %s -> %s\n", r.Name, r.Path)
+			}
+		}
+		fmt.Fprintf(db.Messages, "Restored %d file(s), %d skipped, %d failed.\n", len(files)-failed-skipped, skipped, failed)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// filterSnapshotPaths keeps the files that are one of the paths or inside one of them, all files when there are none
+func filterSnapshotPaths(files []db.SnapshotFile, paths []string) []db.SnapshotFile {
+	if len(paths) == 0 {
+		return files
+	}
+	var kept []db.SnapshotFile
+	for _, f := range files {
+		for _, p := range paths {
+			p = strings.Trim(filepath.ToSlash(p), "/")
+			if f.Path == p || strings.HasPrefix(f.Path, p+"/") {
+				kept = append(kept, f)
+				break
+			}
+		}
+	}
+	return kept
+}
@@ -37,7 +37,14 @@ func init() {
 
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.disvault.yaml)")
 	rootCmd.Root().CompletionOptions.DisableDefaultCmd = true
+	rootCmd.PersistentPostRun = afterCommand
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
+
+// afterCommand runs the housekeeping every command ends with, it is skipped by commands exiting with an error
+func afterCommand(cmd *cobra.Command, args []string) {
+	purgeExpiredTrash()
+	autoBackup()
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the search command
+var searchLimit int
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Full-text search over file names, tags and notes",
+	Long: `Search looks up files by the words in their names, tags and notes and shows the best matches first,
+with the matching words highlighted in [brackets].
+
+Words are matched as a whole, end a word with * to match everything starting with it
+and put words in double quotes to find them next to each other. OR and NOT work between words.
+
+Example usage:
+	disvault search "quarterly report"
+	disvault search 'quart* 2024'
+	disvault search '"annual report" NOT draft'`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runSearchCmd,
+}
+
+func init() {
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 20, "Maximum number of results shown")
+
+	rootCmd.AddCommand(searchCmd)
+}
+
+// searchResult is a file matched by the full-text search
+type searchResult struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Size        int64   `json:"size"` // Size in bytes
+	Group       string  `json:"group"`
+	GroupPath   string  `json:"group_path"`
+	Rank        float64 `json:"rank"`        // bm25 rank, lower is a better match
+	Highlighted string  `json:"highlighted"` // Name with the matches in [brackets]
+	Tags        string  `json:"tags"`        // Tags with the matches in [brackets]
+	Snippet     string  `json:"snippet"`     // Part of the notes around the matches
+}
+
+func runSearchCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	match := ftsQuery(strings.Join(args, " "))
+	if match == "" {
+		fmt.Fprintln(db.Messages, "Error: The search query is empty.")
+		return
+	}
+
+	matches, err := db.Default.SearchFiles(context.Background(), match, searchLimit)
+	if err != nil {
+		log.Fatalf("Error searching files: %v", err)
+	}
+
+	results := []searchResult{}
+	for _, m := range matches {
+		results = append(results, searchResult{
+			ID:          m.ID,
+			Name:        m.Name,
+			Size:        m.Size,
+			Group:       m.GroupName,
+			GroupPath:   m.GroupPath,
+			Rank:        m.Rank,
+			Highlighted: m.Highlighted,
+			Tags:        m.Tags,
+			Snippet:     m.Snippet,
+		})
+	}
+
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(db.Messages, "No files matched your search.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tFILE SIZE\tFILE GROUP\tTAGS\tNOTES")
+	for _, r := range results {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Highlighted, formatBytes(r.Size), r.GroupPath, r.Tags, r.Snippet)
+	}
+	writer.Flush()
+}
+
+// ftsQuery turns what the user typed into an FTS5 query. Every word is quoted so dots, dashes
+// and the like in file names don't break the query syntax, while keeping "phrases",
+// prefix* searches and the OR/NOT operators working.
+func ftsQuery(input string) string {
+	var terms []string
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+
+	for input = strings.TrimSpace(input); input != ""; input = strings.TrimSpace(input) {
+		// A phrase in double quotes
+		if input[0] == '"' {
+			end := strings.IndexByte(input[1:], '"')
+			if end < 0 {
+				end = len(input) - 1
+			}
+			phrase := strings.TrimSpace(input[1 : end+1])
+			input = input[min(end+2, len(input)):]
+			if phrase != "" {
+				terms = append(terms, quote(phrase))
+			}
+			continue
+		}
+
+		word, rest, _ := strings.Cut(input, " ")
+		input = rest
+
+		switch {
+		case word == "OR" || word == "NOT" || word == "AND":
+			terms = append(terms, word)
+		case strings.HasSuffix(word, "*") && len(word) > 1:
+			terms = append(terms, quote(strings.TrimRight(word, "*"))+"*")
+		default:
+			terms = append(terms, quote(word))
+		}
+	}
+
+	// Operators need a term on both sides
+	for len(terms) > 0 && isFTSOperator(terms[0]) {
+		terms = terms[1:]
+	}
+	for len(terms) > 0 && isFTSOperator(terms[len(terms)-1]) {
+		terms = terms[:len(terms)-1]
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// isFTSOperator reports whether term is one of the FTS5 boolean operators
+func isFTSOperator(term string) bool {
+	return term == "OR" || term == "NOT" || term == "AND"
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AnkanNandi/disvault/db"
+)
+
+// fileSelector describes the files a bulk command (delete, download) works on.
+// All the given criteria have to match, i.e. IDs 1-10 with a search only picks
+// the files between 1 and 10 whose name contains the keywords.
+type fileSelector struct {
+	ids       []db.IDRange  // Explicit file IDs and ranges of them
+	group     string        // Group name or ID
+	search    string        // Keywords matched against the file name
+	olderThan time.Duration // Only files uploaded before now - olderThan
+	all       bool          // Select every file, the other criteria still apply
+	states    []string      // States of the files, only active files when empty
+	versions  bool          // Also select versions replaced by a newer upload
+}
+
+// empty reports whether no criteria were given, bulk commands refuse to guess in that case
+func (s fileSelector) empty() bool {
+	return len(s.ids) == 0 && s.group == "" && s.search == "" && s.olderThan == 0 && !s.all
+}
+
+// selectFiles returns the files matched by the selector ordered by their ID
+func selectFiles(s fileSelector) ([]db.File, error) {
+	filter := db.FileFilter{IDRanges: s.ids, Search: s.search, States: s.states, AllVersions: s.versions}
+	if s.group != "" {
+		groupID, err := resolveGroup(s.group)
+		if err != nil {
+			return nil, err
+		}
+		filter.GroupID = groupID
+	}
+	if s.olderThan > 0 {
+		filter.UploadedBefore = time.Now().Add(-s.olderThan)
+	}
+
+	files, err := db.Default.ListFiles(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Let the user know about IDs that don't exist instead of silently ignoring them
+	for _, id := range missingIDs(s, files) {
+		fmt.Fprintf(db.Messages, "Warning: no file found with ID: %d\n", id)
+	}
+
+	return files, nil
+}
+
+// missingIDs returns the single IDs of the selector that aren't among the selected files. Gaps in a
+// range are expected, deleted files leave them, and with other criteria an ID may rightly not match.
+func missingIDs(s fileSelector, files []db.File) []int {
+	if s.group != "" || s.search != "" || s.olderThan != 0 {
+		return nil
+	}
+	found := make(map[int]bool, len(files))
+	for _, f := range files {
+		found[f.ID] = true
+	}
+	var missing []int
+	for _, r := range s.ids {
+		if r.From == r.To && !found[r.From] {
+			missing = append(missing, r.From)
+		}
+	}
+	return missing
+}
+
+// maxRangeSize is the most IDs a range given on the command line may span, bigger ones are almost certainly a typo
+const maxRangeSize = 1_000_000
+
+// ParseFileIDs parses a list of file IDs where each argument is either a single ID or an inclusive range like 7-12.
+// A single ID is returned as a range from and to itself. Duplicates are removed and the order of first appearance is kept.
+func ParseFileIDs(args []string) ([]db.IDRange, error) {
+	var ids []db.IDRange
+	seen := make(map[db.IDRange]bool)
+	add := func(r db.IDRange) {
+		if !seen[r] {
+			seen[r] = true
+			ids = append(ids, r)
+		}
+	}
+
+	for _, arg := range args {
+		from, to, isRange := strings.Cut(arg, "-")
+		if !isRange {
+			id, err := ParseFileID(arg)
+			if err != nil {
+				return nil, err
+			}
+			add(db.IDRange{From: id, To: id})
+			continue
+		}
+
+		start, err := ParseFileID(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range '%s': %w", arg, err)
+		}
+		end, err := ParseFileID(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range '%s': %w", arg, err)
+		}
+		if start > end {
+			return nil, fmt.Errorf("invalid range '%s': start is bigger than end", arg)
+		}
+		if end-start >= maxRangeSize {
+			return nil, fmt.Errorf("invalid range '%s': spans more than %d IDs", arg, maxRangeSize)
+		}
+		add(db.IDRange{From: start, To: end})
+	}
+
+	return ids, nil
+}
+
+// parseAge parses durations like 90m, 12h, 30d or 2w. On top of what time.ParseDuration
+// understands it accepts d (days) and w (weeks) since those are what people use for file ages.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := s[len(s)-1]
+	if unit == 'd' || unit == 'w' {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		day := 24 * time.Hour
+		if unit == 'w' {
+			return time.Duration(n) * 7 * day, nil
+		}
+		return time.Duration(n) * day, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return d, nil
+}
+
+// Status values of a fileResult
+const (
+	statusOK      = "ok"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+)
+
+// fileResult is the outcome of a bulk operation on a single file, used for the command summaries
+type fileResult struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"` // Size in bytes
+	Status string `json:"status"`
+	Path   string `json:"path"` // Local path, only set by downloads
+	Error  string `json:"error"`
+}
+
+// newFileResult builds the result of a bulk operation on file from the error it returned
+func newFileResult(file db.File, err error) fileResult {
+	result := fileResult{ID: file.ID, Name: file.Name, Size: file.Size, Status: statusOK}
+	if err != nil {
+		result.Status = statusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// parseSize parses sizes like 500, 10KB, 1.5M or 2GB into bytes, using the same 1024 based units as formatBytes
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"GB", 1 << 30}, {"G", 1 << 30},
+		{"MB", 1 << 20}, {"M", 1 << 20},
+		{"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	value := strings.ToUpper(strings.TrimSpace(s))
+	factor := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * factor), nil
+}
+
+// parseDate parses a date (2024-01-31), a date and time (2024-01-31 15:04) or an RFC 3339 timestamp,
+// dates without a time zone are in local time
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s', use YYYY-MM-DD, 'YYYY-MM-DD HH:MM' or RFC 3339", s)
+}
+
+// totalSize sums up the size of the given files in bytes
+func totalSize(files []db.File) int64 {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total
+}
+
+// confirm asks the user a yes/no question on the terminal, anything but y/yes counts as no
+func confirm(question string) bool {
+	fmt.Fprintf(db.Messages, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
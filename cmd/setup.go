@@ -14,8 +14,13 @@ import (
 
 // Flags for the setup command
 var (
-	botToken  string
-	channelID string
+	botToken      string
+	channelID     string
+	encryptionKey string
+	autoBackupDB  bool
+	trashDays     int
+	versionsKeep  int
+	versionsDays  int
 )
 
 // setupCmd represents the setup command
@@ -29,6 +34,11 @@ var setupCmd = &cobra.Command{
 func init() {
 	setupCmd.Flags().StringVarP(&botToken, "token", "t", "", "Discord bot token")
 	setupCmd.Flags().StringVarP(&channelID, "channel", "c", "", "Discord channel ID")
+	setupCmd.Flags().StringVarP(&encryptionKey, "encryption-key", "k", "", "Passphrase to encrypt the file names written into part messages")
+	setupCmd.Flags().BoolVar(&autoBackupDB, "auto-backup", false, "Upload a backup of the database after every command that changes it")
+	setupCmd.Flags().IntVar(&trashDays, "trash-days", 0, "Days deleted files stay in the trash before they are purged, -1 keeps them until the trash is emptied (default 30)")
+	setupCmd.Flags().IntVar(&versionsKeep, "versions-keep", 0, "Number of versions of a file the prune command keeps")
+	setupCmd.Flags().IntVar(&versionsDays, "versions-keep-days", 0, "Days the prune command keeps a version after it was replaced")
 	setupCmd.MarkFlagRequired("token")   // Make the token flag mandatory
 	setupCmd.MarkFlagRequired("channel") // Make the channel flag mandatory
 
@@ -39,7 +49,7 @@ func runSetupCmd(cmd *cobra.Command, args []string) {
 	db.InitDatabase()
 	// Ensure both flags are provided
 	if botToken == "" || channelID == "" {
-		fmt.Println("Error: Both -t (token) and -c (channel) flags are required.")
+		fmt.Fprintln(db.Messages, "Error: Both -t (token) and -c (channel) flags are required.")
 		return
 	}
 
@@ -64,10 +74,25 @@ func runSetupCmd(cmd *cobra.Command, args []string) {
 	}
 
 	// Prepare configuration data
-	config := map[string]string{
+	config := map[string]interface{}{
 		"bot_token":  botToken,
 		"channel_id": channelID,
 	}
+	if encryptionKey != "" {
+		config["encryption_key"] = encryptionKey
+	}
+	if autoBackupDB {
+		config["auto_backup"] = true
+	}
+	if trashDays != 0 {
+		config["trash_days"] = trashDays
+	}
+	if versionsKeep > 0 {
+		config["versions_keep"] = versionsKeep
+	}
+	if versionsDays > 0 {
+		config["versions_keep_days"] = versionsDays
+	}
 
 	// Create the data directory if it doesn't exist
 	dataDir := "data"
@@ -89,5 +114,5 @@ func runSetupCmd(cmd *cobra.Command, args []string) {
 		log.Fatalf("Error writing configuration to config.json: %v", err)
 	}
 
-	fmt.Printf("Configuration saved successfully in %s.\n", configPath)
+	fmt.Fprintf(db.Messages, "Configuration saved successfully in %s.\n", configPath)
 }
@@ -0,0 +1,349 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// snapshotsCmd represents the snapshots command
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "List, compare and name the snapshots recorded by the backup command",
+	Long: `Every run of the backup command records a snapshot: the path and file version of every file
+the directory held at that point. A snapshot is given by its ID or its name, the restore command
+downloads the files of a snapshot again.
+
+Versions recorded in a snapshot are never pruned, deleting a snapshot only forgets it and leaves
+its versions in the vault.
+
+Example usage:
+	disvault snapshots list ~/Documents
+	disvault snapshots show before-cleanup
+	disvault snapshots diff 3 7
+	disvault snapshots name 7 before-cleanup`,
+}
+
+// snapshotsListCmd represents the snapshots list command
+var snapshotsListCmd = &cobra.Command{
+	Use:   "list [dir]",
+	Short: "List the snapshots, only those of the directory if one is given",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runSnapshotsListCmd,
+}
+
+// snapshotsShowCmd represents the snapshots show command
+var snapshotsShowCmd = &cobra.Command{
+	Use:   "show <snapshot>",
+	Short: "List the files recorded in a snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run:   runSnapshotsShowCmd,
+}
+
+// snapshotsDiffCmd represents the snapshots diff command
+var snapshotsDiffCmd = &cobra.Command{
+	Use:   "diff <snapshot> <snapshot>",
+	Short: "Show the files added, removed and modified between two snapshots",
+	Args:  cobra.ExactArgs(2),
+	Run:   runSnapshotsDiffCmd,
+}
+
+// snapshotsNameCmd represents the snapshots name command
+var snapshotsNameCmd = &cobra.Command{
+	Use:   "name <snapshot> [name]",
+	Short: "Name a snapshot, the name is removed when none is given",
+	Args:  cobra.RangeArgs(1, 2),
+	Run:   runSnapshotsNameCmd,
+}
+
+// snapshotsDeleteCmd represents the snapshots delete command
+var snapshotsDeleteCmd = &cobra.Command{
+	Use:   "delete <snapshot>",
+	Short: "Forget a snapshot, its file versions stay in the vault",
+	Args:  cobra.ExactArgs(1),
+	Run:   runSnapshotsDeleteCmd,
+}
+
+func init() {
+	snapshotsCmd.AddCommand(snapshotsListCmd, snapshotsShowCmd, snapshotsDiffCmd, snapshotsNameCmd, snapshotsDeleteCmd)
+	rootCmd.AddCommand(snapshotsCmd)
+}
+
+// snapshotRecord is a snapshot in the machine-readable output formats
+type snapshotRecord struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Root      string    `json:"root"`
+	Created   time.Time `json:"created"`
+	Files     int       `json:"files"`
+	Size      int64     `json:"size"` // Total size of the files in bytes
+	Added     int       `json:"added"`
+	Changed   int       `json:"changed"`
+	Unchanged int       `json:"unchanged"`
+	Removed   int       `json:"removed"`
+	Failed    int       `json:"failed"`
+}
+
+// snapshotFileRecord is a file of a snapshot in the machine-readable output formats
+type snapshotFileRecord struct {
+	Path    string `json:"path"`
+	FileID  int    `json:"file_id"` // 0 when the version was deleted from the vault
+	Version int    `json:"version"`
+	Size    int64  `json:"size"` // Size in bytes
+	Hash    string `json:"hash"`
+}
+
+// Values of snapshotChange.Change
+const (
+	changeAdded    = "added"
+	changeRemoved  = "removed"
+	changeModified = "modified"
+)
+
+// snapshotChange is a difference between two snapshots
+type snapshotChange struct {
+	Path      string `json:"path"`
+	Change    string `json:"change"`
+	OldFileID int    `json:"old_file_id"`
+	NewFileID int    `json:"new_file_id"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+}
+
+// resolveSnapshot returns the snapshot with the name or ID
+func resolveSnapshot(ref string) (db.Snapshot, error) {
+	return db.Default.ResolveSnapshot(context.Background(), ref)
+}
+
+func runSnapshotsListCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+	ctx := context.Background()
+
+	var rootID int
+	if len(args) == 1 {
+		dir, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalf("Error resolving %s: %v", args[0], err)
+		}
+		root, err := db.Default.BackupRootByPath(ctx, dir)
+		if err != nil {
+			fmt.Fprintf(db.Messages, "Error: %v\n", err)
+			return
+		}
+		rootID = root.ID
+	}
+
+	snapshots, err := db.Default.ListSnapshots(ctx, rootID)
+	if err != nil {
+		log.Fatalf("Error fetching snapshots: %v", err)
+	}
+
+	if machineOutput() {
+		records := make([]snapshotRecord, 0, len(snapshots))
+		for _, s := range snapshots {
+			records = append(records, snapshotRecord{
+				ID: s.ID, Name: s.Name, Root: s.RootPath, Created: s.Created, Files: s.Files, Size: s.Size,
+				Added: s.Added, Changed: s.Changed, Unchanged: s.Unchanged, Removed: s.Removed, Failed: s.Failed,
+			})
+		}
+		if err := render(stdout, records); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	if len(snapshots) == 0 {
+		fmt.Fprintln(db.Messages, "No snapshots found, the backup command records them.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "ID\tNAME\tDIRECTORY\tCREATED\tFILES\tSIZE\tADDED\tCHANGED\tREMOVED\tFAILED")
+	for _, s := range snapshots {
+		name := s.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%d\t%s\t%d\t%d\t%d\t%d\n",
+			s.ID, name, s.RootPath, formatTime(s.Created), s.Files, formatBytes(s.Size), s.Added, s.Changed, s.Removed, s.Failed)
+	}
+	writer.Flush()
+}
+
+func runSnapshotsShowCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	snapshot, err := resolveSnapshot(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	files, err := db.Default.SnapshotFiles(context.Background(), snapshot.ID)
+	if err != nil {
+		log.Fatalf("Error fetching snapshot files: %v", err)
+	}
+
+	if machineOutput() {
+		records := make([]snapshotFileRecord, 0, len(files))
+		for _, f := range files {
+			records = append(records, snapshotFileRecord{Path: f.Path, FileID: f.FileID, Version: f.Version, Size: f.Size, Hash: f.Hash})
+		}
+		if err := render(stdout, records); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	fmt.Fprintf(db.Messages, "Snapshot %d of %s, created %s:\n\n", snapshot.ID, snapshot.RootPath, formatTime(snapshot.Created))
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "PATH\tFILE ID\tVERSION\tFILE SIZE")
+	for _, f := range files {
+		if f.FileID == 0 {
+			fmt.Fprintf(writer, "%s\t-\t-\tdeleted from the vault\n", f.Path)
+			continue
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%s\n", f.Path, f.FileID, f.Version, formatBytes(f.Size))
+	}
+	writer.Flush()
+
+	fmt.Fprintf(db.Messages, "\n%d file(s), %s in total.\n", snapshot.Files, formatBytes(snapshot.Size))
+}
+
+func runSnapshotsDiffCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+	ctx := context.Background()
+
+	var snapshots [2]db.Snapshot
+	var files [2][]db.SnapshotFile
+	for i, ref := range args {
+		var err error
+		snapshots[i], err = resolveSnapshot(ref)
+		if err != nil {
+			fmt.Fprintf(db.Messages, "Error: %v\n", err)
+			return
+		}
+		files[i], err = db.Default.SnapshotFiles(ctx, snapshots[i].ID)
+		if err != nil {
+			log.Fatalf("Error fetching snapshot files: %v", err)
+		}
+	}
+	if snapshots[0].RootID != snapshots[1].RootID {
+		fmt.Fprintf(db.Messages, "Warning: the snapshots are of different directories, %s and %s.\n", snapshots[0].RootPath, snapshots[1].RootPath)
+	}
+
+	changes := diffSnapshots(files[0], files[1])
+	if machineOutput() {
+		if err := render(stdout, changes); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(db.Messages, "Snapshots %d and %d hold the same files.\n", snapshots[0].ID, snapshots[1].ID)
+		return
+	}
+
+	counts := make(map[string]int)
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "CHANGE\tPATH\tOLD SIZE\tNEW SIZE")
+	for _, c := range changes {
+		oldSize, newSize := "-", "-"
+		if c.Change != changeAdded {
+			oldSize = formatBytes(c.OldSize)
+		}
+		if c.Change != changeRemoved {
+			newSize = formatBytes(c.NewSize)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", c.Change, c.Path, oldSize, newSize)
+		counts[c.Change]++
+	}
+	writer.Flush()
+
+	fmt.Fprintf(db.Messages, "\n%d added, %d removed, %d modified.\n", counts[changeAdded], counts[changeRemoved], counts[changeModified])
+}
+
+// diffSnapshots compares the files of two snapshots by path, both ordered by path. A path whose
+// version changed is modified unless both versions are known to have the same content.
+func diffSnapshots(from, to []db.SnapshotFile) []snapshotChange {
+	changes := []snapshotChange{}
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case j == len(to) || (i < len(from) && from[i].Path < to[j].Path):
+			changes = append(changes, snapshotChange{Path: from[i].Path, Change: changeRemoved, OldFileID: from[i].FileID, OldSize: from[i].Size})
+			i++
+		case i == len(from) || to[j].Path < from[i].Path:
+			changes = append(changes, snapshotChange{Path: to[j].Path, Change: changeAdded, NewFileID: to[j].FileID, NewSize: to[j].Size})
+			j++
+		default:
+			a, b := from[i], to[j]
+			sameContent := a.Hash != "" && a.Hash == b.Hash
+			if a.FileID != b.FileID && !sameContent {
+				changes = append(changes, snapshotChange{
+					Path: a.Path, Change: changeModified,
+					OldFileID: a.FileID, NewFileID: b.FileID, OldSize: a.Size, NewSize: b.Size,
+				})
+			}
+			i++
+			j++
+		}
+	}
+	return changes
+}
+
+func runSnapshotsNameCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	snapshot, err := resolveSnapshot(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	var name string
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	err = db.Default.NameSnapshot(context.Background(), snapshot.ID, name)
+	if errors.Is(err, db.ErrSnapshotExists) {
+		fmt.Fprintf(db.Messages, "Error: %v: %s\n", err, name)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error naming snapshot: %v", err)
+	}
+	markDatabaseChanged()
+
+	if name == "" {
+		fmt.Fprintf(db.Messages, "Removed the name of snapshot %d.\n", snapshot.ID)
+		return
+	}
+	fmt.Fprintf(db.Messages, "Snapshot %d is now named '%s'.\n", snapshot.ID, name)
+}
+
+func runSnapshotsDeleteCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	snapshot, err := resolveSnapshot(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	if err := db.Default.DeleteSnapshot(context.Background(), snapshot.ID); err != nil {
+		log.Fatalf("Error deleting snapshot: %v", err)
+	}
+	markDatabaseChanged()
+	fmt.Fprintf(db.Messages, "Deleted snapshot %d, its file versions can be pruned now unless another snapshot records them.\n", snapshot.ID)
+}
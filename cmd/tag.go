@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// validTag matches the allowed tag names, tags are stored in lower case
+var validTag = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_.\-]*$`)
+
+// tagCmd represents the tag command
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Manage the tags of files",
+	Long: `Tags label files independently of their group, a file belongs to one group but can
+have any number of tags. Use list --tag to find the files with a tag.
+
+Example usage:
+	disvault tag add 4 contract signed
+	disvault tag remove 4 signed
+	disvault tag list
+	disvault list --tag contract --tag signed`,
+}
+
+// tagAddCmd represents the tag add command
+var tagAddCmd = &cobra.Command{
+	Use:   "add <file_id> <tag>...",
+	Short: "Add tags to a file",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   runTagAddCmd,
+}
+
+// tagRemoveCmd represents the tag remove command
+var tagRemoveCmd = &cobra.Command{
+	Use:   "remove <file_id> <tag>...",
+	Short: "Remove tags from a file",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   runTagRemoveCmd,
+}
+
+// tagListCmd represents the tag list command
+var tagListCmd = &cobra.Command{
+	Use:   "list [file_id]",
+	Short: "List all tags, or the tags of a file",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runTagListCmd,
+}
+
+func init() {
+	tagCmd.AddCommand(tagAddCmd, tagRemoveCmd, tagListCmd)
+	rootCmd.AddCommand(tagCmd)
+}
+
+// tagRecord is how a tag is written in the machine-readable output formats
+type tagRecord struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"` // Number of files with the tag
+}
+
+func runTagAddCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	fileID, fileName, tags := parseTagArgs(cmd, args)
+
+	if err := db.Default.AddTags(context.Background(), fileID, tags); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	markDatabaseChanged()
+
+	fmt.Fprintf(db.Messages, "Tagged '%s' with: %s\n", fileName, strings.Join(tags, ", "))
+}
+
+func runTagRemoveCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	fileID, fileName, tags := parseTagArgs(cmd, args)
+
+	// Tags that aren't used by any file anymore are removed altogether
+	if err := db.Default.RemoveTags(context.Background(), fileID, tags); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	markDatabaseChanged()
+
+	fmt.Fprintf(db.Messages, "Removed from '%s': %s\n", fileName, strings.Join(tags, ", "))
+}
+
+func runTagListCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	ctx := context.Background()
+	var found []db.Tag
+	var err error
+
+	if len(args) == 1 {
+		fileID, parseErr := ParseFileID(args[0])
+		if parseErr != nil {
+			fmt.Fprintf(db.Messages, "Error: %v\n", parseErr)
+			return
+		}
+		if _, err := FetchFileNameByID(fileID); err != nil {
+			log.Fatalf("Error fetching file: %v", err)
+		}
+		found, err = db.Default.FileTags(ctx, fileID)
+	} else {
+		found, err = db.Default.ListTags(ctx)
+	}
+	if err != nil {
+		log.Fatalf("Error fetching tags: %v", err)
+	}
+
+	tags := []tagRecord{}
+	for _, t := range found {
+		tags = append(tags, tagRecord{Name: t.Name, Files: t.Files})
+	}
+
+	if machineOutput() {
+		if err := render(stdout, tags); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	if len(tags) == 0 {
+		fmt.Fprintln(db.Messages, "No tags found.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "TAG\tFILES")
+	for _, t := range tags {
+		fmt.Fprintf(writer, "%s\t%d\n", t.Name, t.Files)
+	}
+	writer.Flush()
+}
+
+// parseTagArgs validates the `<file_id> <tag>...` arguments of tag add and remove
+func parseTagArgs(cmd *cobra.Command, args []string) (int, string, []string) {
+	fileID, err := ParseFileID(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	fileName, err := FetchFileNameByID(fileID)
+	if err != nil {
+		log.Fatalf("Error fetching file: %v", err)
+	}
+
+	tags, err := normalizeTags(args[1:])
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	return fileID, fileName, tags
+}
+
+// normalizeTags lower cases the tags, splits comma separated lists and checks the tag names
+func normalizeTags(args []string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		for _, tag := range strings.Split(arg, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if !validTag.MatchString(tag) {
+				return nil, fmt.Errorf("'%s' is not a valid tag, use letters, numbers, '_', '-' and '.'", tag)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no tags given")
+	}
+	return tags, nil
+}
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the trash commands
+var (
+	trashRestoreAll bool
+	trashEmptyYes   bool
+	trashWorkers    int
+)
+
+// trashCmd represents the trash command
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List, restore and empty the deleted files",
+	Long: `Files removed with the delete command are moved to the trash. Their parts stay on Discord
+so they can be restored, until they were in the trash for trash_days (30 by default, set it with
+setup --trash-days) or the trash is emptied. Expired files are purged by the next delete, upload,
+prune or trash empty, or right away with trash purge.
+
+Example usage:
+	disvault trash list
+	disvault trash restore 4 7-9
+	disvault trash empty
+	disvault trash purge`,
+}
+
+// trashListCmd represents the trash list command
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the files in the trash",
+	Args:  cobra.NoArgs,
+	Run:   runTrashListCmd,
+}
+
+// trashRestoreCmd represents the trash restore command
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore [file_id...]",
+	Short: "Move files out of the trash",
+	Run:   runTrashRestoreCmd,
+}
+
+// trashEmptyCmd represents the trash empty command
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty [file_id...]",
+	Short: "Delete the files in the trash permanently, all of them unless IDs are given",
+	Run:   runTrashEmptyCmd,
+}
+
+// trashPurgeCmd represents the trash purge command
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete the files that were in the trash for longer than trash_days permanently",
+	Args:  cobra.NoArgs,
+	Run:   runTrashPurgeCmd,
+}
+
+func init() {
+	trashRestoreCmd.Flags().BoolVar(&trashRestoreAll, "all", false, "Restore every file in the trash")
+	trashEmptyCmd.Flags().BoolVarP(&trashEmptyYes, "yes", "y", false, "Don't ask for confirmation")
+	trashEmptyCmd.Flags().IntVarP(&trashWorkers, "workers", "w", core.DefaultWorkers, "Number of files deleted at the same time")
+	trashPurgeCmd.Flags().IntVarP(&trashWorkers, "workers", "w", core.DefaultWorkers, "Number of files deleted at the same time")
+
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd, trashEmptyCmd, trashPurgeCmd)
+	rootCmd.AddCommand(trashCmd)
+}
+
+// trashedFiles returns the files in the trash, only the given IDs when there are any
+func trashedFiles(args []string) ([]db.File, error) {
+	ids, err := ParseFileIDs(args)
+	if err != nil {
+		return nil, err
+	}
+	return selectFiles(fileSelector{ids: ids, all: true, states: []string{db.StateTrashed}})
+}
+
+func runTrashListCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	files, err := trashedFiles(nil)
+	if err != nil {
+		log.Fatalf("Error fetching files: %v", err)
+	}
+
+	if machineOutput() {
+		if err := render(stdout, fileRecords(files)); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(db.Messages, "The trash is empty.")
+		return
+	}
+
+	retention, purged := app.Config.TrashRetention()
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "FILE ID\tFILE NAME\tFILE SIZE\tFILE GROUP\tDELETED\tPURGED AFTER")
+	for _, f := range files {
+		purgeAt := "never"
+		if purged {
+			purgeAt = formatTime(f.DeletedAt.Add(retention))
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			f.ID, f.Name, formatBytes(f.Size), f.GroupName, formatTime(f.DeletedAt), purgeAt)
+	}
+	writer.Flush()
+
+	fmt.Fprintf(db.Messages, "\n%d file(s), %s in total in the trash.\n", len(files), formatBytes(totalSize(files)))
+}
+
+func runTrashRestoreCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	if len(args) == 0 && !trashRestoreAll {
+		fmt.Fprintln(db.Messages, "Error: Provide the IDs of the files to restore or --all.")
+		cmd.Help()
+		return
+	}
+	files, err := trashedFiles(args)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(db.Messages, "No files in the trash matched your criteria.")
+		return
+	}
+
+	restored, err := db.Default.RestoreFiles(context.Background(), fileIDs(files))
+	if err != nil {
+		log.Fatalf("Error restoring files: %v", err)
+	}
+	markDatabaseChanged()
+
+	if machineOutput() {
+		results := make([]fileResult, len(files))
+		for i, f := range files {
+			results[i] = newFileResult(f, nil)
+		}
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	fmt.Fprintf(db.Messages, "Restored %d file(s) from the trash.\n", restored)
+}
+
+func runTrashEmptyCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	files, err := trashedFiles(args)
+	if err == nil {
+		files, err = withVersions(files)
+	}
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(db.Messages, "The trash is empty.")
+		return
+	}
+
+	listAllFiles(files)
+	fmt.Fprintf(db.Messages, "\n%d file(s), %s in total will be deleted permanently.\n", len(files), formatBytes(totalSize(files)))
+	if !trashEmptyYes && !confirm("Do you want to continue?") {
+		fmt.Fprintln(db.Messages, "Aborted, nothing was deleted.")
+		return
+	}
+	markTrashPurgeDue()
+
+	results, failed := deletePermanently(files, trashWorkers)
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		fmt.Fprintf(db.Messages, "Deleted %d file(s), %d failed.\n", len(files)-failed, failed)
+	}
+	if failed > 0 {
+		fmt.Fprintln(db.Messages, "The failed files are pending, run `disvault delete --pending` to finish deleting them.")
+		autoBackup()
+		os.Exit(1)
+	}
+}
+
+func runTrashPurgeCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	retention, ok := app.Config.TrashRetention()
+	if !ok {
+		fmt.Fprintln(db.Messages, "Files stay in the trash until it is emptied, set trash_days with setup --trash-days to purge them.")
+		return
+	}
+	files, err := expiredTrash(retention)
+	if err != nil {
+		log.Fatalf("Error fetching files: %v", err)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(db.Messages, "No files were in the trash for more than %d day(s).\n", int(retention.Hours()/24))
+		return
+	}
+
+	results, failed := deletePermanently(files, trashWorkers)
+	if machineOutput() {
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+	} else {
+		fmt.Fprintf(db.Messages, "Purged %d file(s), %d failed.\n", len(files)-failed, failed)
+	}
+	if failed > 0 {
+		fmt.Fprintln(db.Messages, "The failed files are pending, run `disvault delete --pending` to finish deleting them.")
+		autoBackup()
+		os.Exit(1)
+	}
+}
+
+// expiredTrash returns the files that were in the trash for longer than the retention
+func expiredTrash(retention time.Duration) ([]db.File, error) {
+	return db.Default.ListFiles(context.Background(), db.FileFilter{
+		// Purges that failed midway are pending and still have their deletion time, they are resumed
+		States:        []string{db.StateTrashed, db.StateDeleting},
+		DeletedBefore: time.Now().Add(-retention),
+		AllVersions:   true,
+	})
+}
+
+// trashPurgeDue is set by the commands that change what is stored on Discord, see purgeExpiredTrash
+var trashPurgeDue bool
+
+// markTrashPurgeDue makes the running command purge the expired files in the trash when it is done.
+// Read-only commands and dry runs never call it, so they never delete anything from Discord.
+func markTrashPurgeDue() {
+	trashPurgeDue = true
+}
+
+// purgeExpiredTrash permanently deletes the files that were in the trash for longer than
+// the configured retention, after commands that called markTrashPurgeDue.
+func purgeExpiredTrash() {
+	retention, ok := app.Config.TrashRetention()
+	if !trashPurgeDue || !ok || app.Session == nil || db.Default == nil {
+		return
+	}
+	trashPurgeDue = false
+
+	files, err := expiredTrash(retention)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Warning: checking the trash failed: %v\n", err)
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	// Machine output gets the results of the command only
+	if !machineOutput() {
+		fmt.Fprintf(db.Messages, "Purging %d file(s) that were in the trash for more than %d day(s)...\n", len(files), int(retention.Hours()/24))
+	}
+	_, failed := deletePermanently(files, core.DefaultWorkers)
+	if failed > 0 {
+		fmt.Fprintf(db.Messages, "Warning: %d file(s) couldn't be purged, the next command tries again.\n", failed)
+	}
+}
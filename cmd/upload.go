@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"log"
 
@@ -54,32 +54,42 @@ func runUploadCmd(cmd *cobra.Command, args []string) {
 	ValidateGroupID(groupID)
 
 	// Call the Upload function from the core package
-	if err := core.Upload(inputFile, groupID); err != nil {
+	fileID, err := core.Upload(inputFile, groupID)
+	if err != nil {
 		log.Fatalf("Failed to upload file: %v", err)
 	}
-	fmt.Println("File uploaded successfully.")
+	markDatabaseChanged()
+	markTrashPurgeDue()
+
+	if machineOutput() {
+		files, err := selectFiles(fileSelector{ids: []db.IDRange{{From: int(fileID), To: int(fileID)}}})
+		if err != nil {
+			log.Fatalf("Failed to fetch the uploaded file: %v", err)
+		}
+		if err := render(stdout, fileRecords(files)); err != nil {
+			log.Fatalf("Failed to write output: %v", err)
+		}
+		return
+	}
+	fmt.Fprintln(db.Messages, "File uploaded successfully.")
 }
 
-// fetchGroupIDByName retrieves the group ID based on the group name from the database.
+// fetchGroupName retrieves the group ID based on the group name from the database.
 func fetchGroupName(gName string) int {
-	var gID int
-	err := db.DB.QueryRow("SELECT group_id FROM groups WHERE group_name = ?", gName).Scan(&gID)
-	switch {
-	case err == sql.ErrNoRows:
-		log.Fatalf("No group found with name: %s", gName)
-	case err != nil:
+	gID, err := db.Default.GroupByName(context.Background(), gName)
+	if err != nil {
 		log.Fatalf("Failed to query group ID: %v", err)
 	}
 	return gID
 }
 
-// validateGroupID checks if the provided group ID exists in the database.
+// ValidateGroupID checks if the provided group ID exists in the database.
 func ValidateGroupID(gid int) {
-	err := db.DB.QueryRow("SELECT group_id FROM groups WHERE group_id = ?", gid).Scan(&gid)
+	exists, err := db.Default.GroupExists(context.Background(), gid)
 	switch {
-	case err == sql.ErrNoRows:
-		log.Fatalf("No group found with ID: %d", gid)
 	case err != nil:
 		log.Fatalf("Failed to query group ID: %v", err)
+	case !exists:
+		log.Fatalf("No group found with ID: %d", gid)
 	}
 }
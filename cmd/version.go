@@ -15,7 +15,7 @@ var versionCmd = &cobra.Command{
 	Short: "Print the version number of disvault",
 	Long:  `All software has versions. This is DisVault's version.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("DisVault version: %s\n", Version)
+		fmt.Fprintf(stdout, "DisVault version: %s\n", Version)
 	},
 }
 
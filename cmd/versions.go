@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"text/tabwriter"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// versionsCmd represents the versions command
+var versionsCmd = &cobra.Command{
+	Use:   "versions <file_id>",
+	Short: "List the versions of a file",
+	Long: `Uploading a file with the same name into the same group again stores it as a new version
+of that file instead of an unrelated one. Only the current version shows up in list, search and
+the other commands, the older ones stay downloadable with download --version until they are
+pruned. Any version ID may be given, renaming, moving, deleting and restoring a file always
+applies to all of its versions.
+
+Example usage:
+	disvault versions 12
+	disvault download 12 --version 3`,
+	Args: cobra.ExactArgs(1),
+	Run:  runVersionsCmd,
+}
+
+func init() {
+	rootCmd.AddCommand(versionsCmd)
+}
+
+func runVersionsCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	id, err := ParseFileID(args[0])
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+	versions, err := db.Default.Versions(context.Background(), id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			fmt.Fprintf(db.Messages, "Error: %v\n", err)
+			return
+		}
+		log.Fatalf("Error fetching versions: %v", err)
+	}
+
+	if machineOutput() {
+		if err := render(stdout, fileRecords(versions)); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	fmt.Fprintf(db.Messages, "Versions of %s in %s:\n\n", versions[0].Name, versions[0].GroupName)
+	writer := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintln(writer, "VERSION\tFILE ID\tFILE SIZE\tSHA-256\tUPLOADED\tCURRENT")
+	for _, v := range versions {
+		current := ""
+		if !v.Replaced {
+			current = "yes"
+		}
+		if v.State != db.StateActive {
+			current = v.State
+		}
+		hash := v.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t%s\n",
+			v.Version, v.ID, formatBytes(v.Size), hash, formatTime(v.UploadedAt), current)
+	}
+	writer.Flush()
+
+	fmt.Fprintf(db.Messages, "\n%d version(s), %s in total.\n", len(versions), formatBytes(totalSize(versions)))
+}
+
+// fileVersion returns the given version of a file, the file may be any of its versions
+func fileVersion(id, version int) (db.File, error) {
+	versions, err := db.Default.Versions(context.Background(), id)
+	if err != nil {
+		return db.File{}, err
+	}
+	for _, v := range versions {
+		if v.Version != version {
+			continue
+		}
+		if v.State != db.StateActive {
+			return db.File{}, fmt.Errorf("version %d of file %d is %s", version, id, v.State)
+		}
+		return v, nil
+	}
+	return db.File{}, fmt.Errorf("file %d has no version %d, run `disvault versions %d` to list them", id, version, id)
+}
+
+// withVersions adds all the versions of the files to them, for commands that remove files for good
+func withVersions(files []db.File) ([]db.File, error) {
+	var all []db.File
+	seen := make(map[int]bool)
+	for _, f := range files {
+		if seen[f.LineageID] {
+			continue
+		}
+		seen[f.LineageID] = true
+
+		versions, err := db.Default.Versions(context.Background(), f.ID)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, versions...)
+	}
+	return all, nil
+}
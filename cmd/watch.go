@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/core"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the watch command
+var (
+	watchGroup     string
+	watchRecursive bool
+	watchSettle    time.Duration
+	watchExclude   []string
+	watchWorkers   int
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir>",
+	Short: "Upload the files created or modified in a directory as they appear",
+	Long: `Watch keeps running and uploads every file that is created, moved in or modified in a directory,
+so screenshot and export folders are archived without thinking about it. A file is uploaded once it
+stopped changing for --settle, files still being written or copied aren't uploaded halfway. A
+modified file is uploaded as a new version of its earlier upload, see the versions command.
+
+Files that already exist when watch starts are left alone, use the backup command for those.
+Hidden files, names ending in ~ and the database directory are never uploaded. With --recursive
+the subdirectories are watched too, including the ones created later, and the files in them are
+named by their path inside the directory.
+
+Stop watching with Ctrl+C, uploads that are running are finished first.
+
+Example usage:
+	disvault watch ~/Pictures/Screenshots --group screenshots
+	disvault watch ~/exports -g exports --recursive --settle 10s --exclude '*.part'`,
+	Args: cobra.ExactArgs(1),
+	Run:  runWatchCmd,
+}
+
+func init() {
+	watchCmd.Flags().StringVarP(&watchGroup, "group", "g", "", "Group the files are uploaded to (name or ID, required)")
+	watchCmd.Flags().BoolVarP(&watchRecursive, "recursive", "r", false, "Also watch the subdirectories")
+	watchCmd.Flags().DurationVar(&watchSettle, "settle", core.DefaultSettle, "How long a file has to stay unchanged before it is uploaded")
+	watchCmd.Flags().StringSliceVarP(&watchExclude, "exclude", "e", nil, "Skip files and directories whose name or path matches the pattern, may be repeated")
+	watchCmd.Flags().IntVarP(&watchWorkers, "workers", "w", core.DefaultWorkers, "Number of files uploaded at the same time")
+	watchCmd.MarkFlagRequired("group")
+
+	rootCmd.AddCommand(watchCmd)
+}
+
+func runWatchCmd(cmd *cobra.Command, args []string) {
+	db.InitDatabase()
+	app.Init()
+
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatalf("Error resolving %s: %v", args[0], err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(db.Messages, "Error: %s is not a directory.\n", dir)
+		return
+	}
+	groupID, err := resolveGroup(watchGroup)
+	if err != nil {
+		fmt.Fprintf(db.Messages, "Error: %v\n", err)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var mu sync.Mutex
+	var results []fileResult
+	uploaded, failed := 0, 0
+	onUpload := func(rel string, size int64, fileID int64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		result := fileResult{ID: int(fileID), Name: rel, Size: size, Status: statusOK, Path: filepath.Join(dir, filepath.FromSlash(rel))}
+		if err != nil {
+			failed++
+			result.Status = statusFailed
+			result.Error = err.Error()
+		} else {
+			uploaded++
+			markDatabaseChanged()
+		}
+		results = append(results, result)
+		if machineOutput() {
+			return
+		}
+		stamp := time.Now().Format("15:04:05")
+		if err != nil {
+			fmt.Fprintf(db.Messages, "%s FAILED  %s: %v\n", stamp, rel, err)
+			return
+		}
+		fmt.Fprintf(db.Messages, "%s OK      %s (%s) -> file ID %d\n", stamp, rel, formatBytes(size), fileID)
+	}
+
+	if !machineOutput() {
+		fmt.Fprintf(db.Messages, "Watching %s, press Ctrl+C to stop.\n", dir)
+	}
+	err = core.Watch(ctx, dir, core.WatchOptions{
+		GroupID:   groupID,
+		Recursive: watchRecursive,
+		Settle:    watchSettle,
+		Exclude:   watchExclude,
+		Workers:   watchWorkers,
+		OnUpload:  onUpload,
+	})
+	if err != nil {
+		autoBackup()
+		log.Fatalf("Error watching %s: %v", dir, err)
+	}
+
+	if machineOutput() {
+		if results == nil {
+			results = []fileResult{}
+		}
+		if err := render(stdout, results); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+	fmt.Fprintf(db.Messages, "\nStopped watching, uploaded %d file(s), %d failed.\n", uploaded, failed)
+}
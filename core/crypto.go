@@ -0,0 +1,129 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// cryptoVersion is the first byte of data sealed by Encrypt, followed by the salt, the nonce and the ciphertext
+const cryptoVersion = 2
+
+// Argon2id parameters, the second recommended option of RFC 9106. Deriving a key takes a noticeable
+// moment on purpose, it is what makes guessing the passphrase of a leaked backup expensive.
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024 // KiB
+	kdfThreads = 4
+	saltSize   = 16
+)
+
+// keys caches the derived keys by passphrase and salt. Encrypt uses one salt per passphrase for
+// the whole run, so the parts of an upload and a rebuild of them only derive the key once.
+var keys = struct {
+	sync.Mutex
+	salts   map[string][]byte // Salt Encrypt uses for a passphrase
+	derived map[string]*derivedKey
+}{salts: make(map[string][]byte), derived: make(map[string]*derivedKey)}
+
+// derivedKey is derived once by the first worker asking for it, the others wait for that one
+// only instead of for every derivation behind the lock of keys
+type derivedKey struct {
+	once sync.Once
+	gcm  cipher.AEAD
+	err  error
+}
+
+// Encrypt seals data with AES-256-GCM using a key derived from the passphrase with Argon2id,
+// the version, the random salt and the random nonce are put in front of the ciphertext
+func Encrypt(passphrase string, data []byte) ([]byte, error) {
+	salt, err := runSalt(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := derivedGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := make([]byte, 0, 1+len(salt)+len(nonce)+len(data)+gcm.Overhead())
+	sealed = append(sealed, cryptoVersion)
+	sealed = append(sealed, salt...)
+	sealed = append(sealed, nonce...)
+	return gcm.Seal(sealed, nonce, data, nil), nil
+}
+
+// Decrypt opens data sealed by Encrypt with the same passphrase
+func Decrypt(passphrase string, data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != cryptoVersion {
+		return nil, errors.New("unknown encryption format")
+	}
+	data = data[1:]
+	if len(data) < saltSize {
+		return nil, errors.New("encrypted data is too short")
+	}
+	gcm, err := derivedGCM(passphrase, data[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[saltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt, wrong encryption key?")
+	}
+	return plain, nil
+}
+
+// runSalt returns the salt Encrypt uses for the passphrase during this run, generating it on first use
+func runSalt(passphrase string) ([]byte, error) {
+	keys.Lock()
+	defer keys.Unlock()
+	if salt, ok := keys.salts[passphrase]; ok {
+		return salt, nil
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	keys.salts[passphrase] = salt
+	return salt, nil
+}
+
+// derivedGCM returns AES-256-GCM with the key Argon2id derives from the passphrase and salt
+func derivedGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	id := passphrase + "\x00" + string(salt)
+	keys.Lock()
+	key, ok := keys.derived[id]
+	if !ok {
+		key = &derivedKey{}
+		keys.derived[id] = key
+	}
+	keys.Unlock()
+
+	key.once.Do(func() {
+		key.gcm, key.err = newGCM(argon2.IDKey([]byte(passphrase), salt, kdfTime, kdfMemory, kdfThreads, 32))
+	})
+	return key.gcm, key.err
+}
+
+// newGCM sets up AES-256-GCM with the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up encryption: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,312 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/bwmarrin/discordgo"
+)
+
+// backupPrefix starts the content of every database backup message, followed by the BackupHeader as JSON.
+// It differs from the prefix of part messages so rebuild never takes a backup for a file.
+const backupPrefix = "disvault-backup:"
+
+// backupPartPrefix starts the content of the messages holding the parts after the first of a database
+// backup too big for one message, followed by a backupPart as JSON
+const backupPartPrefix = "disvault-backup-part:"
+
+// backupVersion is the version of BackupHeader written by this build, 2 added PartIDs
+const backupVersion = 2
+
+// maxMessageContent is the most characters Discord allows in the text of a message
+const maxMessageContent = 2000
+
+// sqliteMagic starts every SQLite database file
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// ErrNoBackup is returned when the channel holds no database backup
+var ErrNoBackup = errors.New("no database backup found in the channel")
+
+// BackupHeader is written into the message of a database backup
+type BackupHeader struct {
+	Version   int       `json:"v"`
+	Created   time.Time `json:"created"`
+	Schema    int       `json:"schema"` // Schema version of the database
+	Encrypted bool      `json:"enc"`    // Encrypted with the configured key
+	Hash      string    `json:"h"`      // SHA-256 of the whole backup, all parts joined
+	Size      int64     `json:"s"`      // Size of the whole backup in bytes
+
+	// Messages holding the parts after the first in order, the backup message holds the first part.
+	// Empty when the backup fits into one message.
+	PartIDs []string `json:"parts,omitempty"`
+}
+
+// backupPart is written into the messages holding the later parts of a database backup
+type backupPart struct {
+	Index int `json:"i"` // Position of the part, the backup message holds part 0
+	Total int `json:"n"` // Number of parts of the backup
+}
+
+// DatabaseBackup is a backup message found in the channel
+type DatabaseBackup struct {
+	MessageID string
+	BackupHeader
+}
+
+// ParseBackupHeader reads the header of a backup message, ok is false for any other message
+func ParseBackupHeader(content string) (header BackupHeader, ok bool, err error) {
+	if !strings.HasPrefix(content, backupPrefix) {
+		return header, false, nil
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(content, backupPrefix)), &header); err != nil {
+		return header, true, fmt.Errorf("invalid backup header: %w", err)
+	}
+	return header, true, nil
+}
+
+// IsBackupMessage reports whether the content is the one of a database backup message or one of its parts
+func IsBackupMessage(content string) bool {
+	return strings.HasPrefix(content, backupPrefix) || strings.HasPrefix(content, backupPartPrefix)
+}
+
+/*
+BackupDatabase copies the database with VACUUM INTO, which gives a consistent snapshot even while
+other commands write to it, and uploads the copy to the channel. The copy is encrypted with the
+configured key when encrypt is set. A copy bigger than a message can hold is split into parts like
+uploaded files, the later parts are sent first so the backup message can list them.
+
+The new backup is pinned so it is found without reading the whole channel, older backups are
+unpinned as Discord only allows 50 pins but stay in the channel.
+*/
+func BackupDatabase(ctx context.Context, encrypt bool) (DatabaseBackup, error) {
+	if encrypt && app.Config.EncryptionKey == "" {
+		return DatabaseBackup{}, errors.New("no encryption key is configured, run `disvault setup` with --encryption-key")
+	}
+
+	schema, err := db.Default.SchemaVersion(ctx)
+	if err != nil {
+		return DatabaseBackup{}, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "disvault-backup-")
+	if err != nil {
+		return DatabaseBackup{}, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	snapshot := filepath.Join(tempDir, "db.sql")
+	if err := db.Default.BackupTo(ctx, snapshot); err != nil {
+		return DatabaseBackup{}, err
+	}
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		return DatabaseBackup{}, fmt.Errorf("failed to read the database copy: %w", err)
+	}
+
+	created := time.Now().UTC()
+	name := fmt.Sprintf("disvault-db-%s.sql", created.Format("20060102-150405"))
+	if encrypt {
+		if data, err = Encrypt(app.Config.EncryptionKey, data); err != nil {
+			return DatabaseBackup{}, err
+		}
+		name += ".enc"
+	}
+
+	sum := sha256.Sum256(data)
+	header := BackupHeader{
+		Version:   backupVersion,
+		Created:   created,
+		Schema:    schema,
+		Encrypted: encrypt,
+		Hash:      hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+	}
+
+	var chunks [][]byte
+	for len(data) > chunkSize {
+		chunks = append(chunks, data[:chunkSize])
+		data = data[chunkSize:]
+	}
+	chunks = append(chunks, data)
+
+	// Check the backup message can list every part before uploading anything, message IDs have at most 20 digits
+	header.PartIDs = make([]string, len(chunks)-1)
+	for i := range header.PartIDs {
+		header.PartIDs[i] = strings.Repeat("0", 20)
+	}
+	if content, err := json.Marshal(header); err != nil || len(backupPrefix)+len(content) > maxMessageContent {
+		return DatabaseBackup{}, fmt.Errorf("the database copy needs %d parts, more than a backup message can list", len(chunks))
+	}
+	header.PartIDs = nil
+
+	// Parts already sent are deleted again when the backup fails, they are useless without the backup message
+	sent := false
+	defer func() {
+		if !sent {
+			for _, id := range header.PartIDs {
+				app.Session.ChannelMessageDelete(app.Config.ChannelID, id)
+			}
+		}
+	}()
+	for i, chunk := range chunks[1:] {
+		part, err := json.Marshal(backupPart{Index: i + 1, Total: len(chunks)})
+		if err != nil {
+			return DatabaseBackup{}, fmt.Errorf("failed to encode backup part: %w", err)
+		}
+		msg, err := app.SendAttachment(fmt.Sprintf("%s.part%d", name, i+1), bytes.NewReader(chunk), backupPartPrefix+string(part))
+		if err != nil {
+			return DatabaseBackup{}, fmt.Errorf("failed to upload part %d of %d: %w", i+2, len(chunks), err)
+		}
+		header.PartIDs = append(header.PartIDs, msg.ID)
+	}
+
+	content, err := json.Marshal(header)
+	if err != nil {
+		return DatabaseBackup{}, fmt.Errorf("failed to encode backup header: %w", err)
+	}
+	if len(chunks) > 1 {
+		name += ".part0"
+	}
+	msg, err := app.SendAttachment(name, bytes.NewReader(chunks[0]), backupPrefix+string(content))
+	if err != nil {
+		return DatabaseBackup{}, err
+	}
+	sent = true
+	backup := DatabaseBackup{MessageID: msg.ID, BackupHeader: header}
+
+	// The backup is uploaded, a failed pin only makes it harder to find
+	if err := app.PinMessage(msg.ID); err != nil {
+		return backup, fmt.Errorf("backup uploaded as message %s but not pinned: %w", msg.ID, err)
+	}
+	pinned, err := pinnedBackups()
+	if err != nil {
+		return backup, err
+	}
+	for _, old := range pinned {
+		if old.MessageID == msg.ID {
+			continue
+		}
+		if err := app.UnpinMessage(old.MessageID); err != nil {
+			return backup, err
+		}
+	}
+	return backup, nil
+}
+
+// LatestBackup returns the newest database backup, looking at the pinned messages first
+// and reading the channel history when none of them is a backup
+func LatestBackup() (DatabaseBackup, error) {
+	pinned, err := pinnedBackups()
+	if err != nil {
+		return DatabaseBackup{}, err
+	}
+	if len(pinned) > 0 {
+		return pinned[0], nil
+	}
+
+	var latest DatabaseBackup
+	errFound := errors.New("found")
+	err = app.ScanChannel(func(messages []*discordgo.Message) error {
+		for _, m := range messages {
+			if backup, ok := backupFromMessage(m); ok {
+				latest = backup
+				return errFound
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errFound):
+		return latest, nil
+	case err != nil:
+		return DatabaseBackup{}, err
+	default:
+		return DatabaseBackup{}, ErrNoBackup
+	}
+}
+
+// BackupByMessage returns the database backup held by the given message
+func BackupByMessage(messageID string) (DatabaseBackup, error) {
+	msg, err := app.Session.ChannelMessage(app.Config.ChannelID, messageID)
+	if err != nil {
+		return DatabaseBackup{}, fmt.Errorf("error retrieving message: %w", err)
+	}
+	backup, ok := backupFromMessage(msg)
+	if !ok {
+		return DatabaseBackup{}, fmt.Errorf("message %s is not a database backup", messageID)
+	}
+	return backup, nil
+}
+
+// DownloadBackup downloads a database backup and its parts, checks it and saves the decrypted database to path
+func DownloadBackup(backup DatabaseBackup, path string) error {
+	data, err := app.DownloadPart(backup.MessageID)
+	if err != nil {
+		return err
+	}
+	for i, id := range backup.PartIDs {
+		part, err := app.DownloadPart(id)
+		if err != nil {
+			return fmt.Errorf("failed to download part %d of %d: %w", i+2, len(backup.PartIDs)+1, err)
+		}
+		data = append(data, part...)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != backup.Hash {
+		return fmt.Errorf("the backup in message %s doesn't match its checksum", backup.MessageID)
+	}
+	if backup.Encrypted {
+		if app.Config.EncryptionKey == "" {
+			return errors.New("the backup is encrypted and no encryption key is configured")
+		}
+		if data, err = Decrypt(app.Config.EncryptionKey, data); err != nil {
+			return err
+		}
+	}
+	if !bytes.HasPrefix(data, sqliteMagic) {
+		return fmt.Errorf("the backup in message %s is not an SQLite database", backup.MessageID)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save the backup to %s: %w", path, err)
+	}
+	return nil
+}
+
+// pinnedBackups returns the pinned database backups, newest first
+func pinnedBackups() ([]DatabaseBackup, error) {
+	messages, err := app.PinnedMessages()
+	if err != nil {
+		return nil, err
+	}
+	var backups []DatabaseBackup
+	for _, m := range messages {
+		if backup, ok := backupFromMessage(m); ok {
+			backups = append(backups, backup)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Created.After(backups[j].Created) })
+	return backups, nil
+}
+
+// backupFromMessage reads the backup held by a message, ok is false for any other message
+func backupFromMessage(m *discordgo.Message) (DatabaseBackup, bool) {
+	header, ok, err := ParseBackupHeader(m.Content)
+	if !ok || err != nil || len(m.Attachments) == 0 {
+		return DatabaseBackup{}, false
+	}
+	return DatabaseBackup{MessageID: m.ID, BackupHeader: header}, true
+}
@@ -6,51 +6,47 @@ import (
 
 	"github.com/AnkanNandi/disvault/app"
 	"github.com/AnkanNandi/disvault/db"
-	"github.com/bwmarrin/discordgo"
 )
 
 /*
 The function deletes all the file parts of a file then finally deletes the file itself
 
-It deletes the file first then it's registration in the parts table.
-
-	'TODO: Add a way to check for sudden stops in delete i.e. program crash in middle of function'
+The file is marked as being deleted first, then every part is deleted from Discord and removed from
+the database right after, so a crash or a failure midway leaves a file that is hidden from the other
+commands and only has the parts that still exist. Calling the function again picks up where it stopped.
+Messages that are already gone, i.e. deleted by hand or by an earlier run, count as deleted.
 */
 func DeleteFileParts(fileID int) error {
 	ctx := context.Background()
-	partIDs, err := db.GetPartIDs(ctx, fileID)
-	if err != nil {
-		return fmt.Errorf("failed to retrieve part IDs: %w", err)
+	if err := db.Default.SetFileState(ctx, fileID, db.StateDeleting); err != nil {
+		return err
 	}
 
-	discord, err := discordgo.New("Bot " + app.Config.BotToken)
+	partIDs, err := db.Default.PartIDs(ctx, fileID)
 	if err != nil {
-		return fmt.Errorf("invalid bot parameters: %w", err)
+		return fmt.Errorf("failed to retrieve part IDs: %w", err)
 	}
-	defer discord.Close()
 
 	for _, partID := range partIDs {
-		// Delete the message (file) from Discord
-		err := discord.ChannelMessageDelete(app.Config.ChannelID, partID)
-		if err != nil {
-			return fmt.Errorf("failed to delete message %s from Discord: %w", partID, err)
+		// Delete the message (file) from Discord, the shared session keeps track of the rate limits
+		err := app.Session.ChannelMessageDelete(app.Config.ChannelID, partID)
+		switch {
+		case err == nil:
+			fmt.Fprintf(db.Messages, "Deleted part %s from Discord\n", partID)
+		case app.IsUnknownMessage(err):
+			fmt.Fprintf(db.Messages, "Part %s was already deleted from Discord\n", partID)
+		default:
+			return fmt.Errorf("failed to delete message %s from Discord, delete the file again to resume: %w", partID, err)
 		}
-		fmt.Printf("Deleted part %s from Discord\n", partID)
-
-		// Delete the part entry from the database
-		_, err = db.DB.ExecContext(ctx, "DELETE FROM parts WHERE part_id = ?", partID)
-		if err != nil {
-			return fmt.Errorf("failed to delete part %s from database: %w", partID, err)
+		if err := db.Default.DeleteParts(ctx, fileID, []string{partID}, false); err != nil {
+			return err
 		}
-		fmt.Printf("Deleted part %s from database\n", partID)
 	}
 
-	// also delete the file entry from the 'files' table
-	_, err = db.DB.ExecContext(ctx, "DELETE FROM files WHERE id = ?", fileID)
-	if err != nil {
-		return fmt.Errorf("failed to delete file ID %d from database: %w", fileID, err)
+	if err := db.Default.DeleteParts(ctx, fileID, nil, true); err != nil {
+		return err
 	}
-	fmt.Printf("Deleted file ID %d from database\n", fileID)
+	fmt.Fprintf(db.Messages, "Deleted file ID %d and its %d part(s) from database\n", fileID, len(partIDs))
 
 	return nil
 }
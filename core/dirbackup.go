@@ -0,0 +1,270 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AnkanNandi/disvault/db"
+)
+
+// Status values of a BackedUpFile
+const (
+	BackupAdded     = "added"
+	BackupChanged   = "changed"
+	BackupUnchanged = "unchanged"
+	BackupRemoved   = "removed"
+	BackupFailed    = "failed"
+)
+
+// DirBackupOptions changes what BackupDirectory does
+type DirBackupOptions struct {
+	Exclude     []string // Glob patterns matched against the relative path and the name of files and directories
+	MarkRemoved bool     // Record the files missing from the directory as removed, they are left out of the snapshot
+	DryRun      bool     // Only report what would be uploaded, nothing is uploaded or recorded
+	Workers     int      // Number of files uploaded at the same time
+	Name        string   // Name of the snapshot, may be empty
+}
+
+// BackedUpFile is what BackupDirectory did with a file of the directory
+type BackedUpFile struct {
+	Path   string // Relative to the root, separated by slashes
+	Size   int64
+	Status string
+	FileID int // Version of the file in the vault, 0 when nothing was uploaded yet
+	Err    error
+}
+
+// DirBackupResult is the outcome of BackupDirectory
+type DirBackupResult struct {
+	Files    []BackedUpFile // Ordered by path
+	Snapshot db.Snapshot    // Not recorded on a dry run
+}
+
+// scannedFile is a regular file found in the directory
+type scannedFile struct {
+	rel  string
+	path string
+	info fs.FileInfo
+}
+
+// BackupDirectory uploads the new and changed files of the directory of root into its group, named by
+// their path relative to the root so a changed file becomes a new version of the previous upload.
+// Files whose size and modification time match the last run aren't read at all, files that were only
+// touched are hashed and skipped. A failed file doesn't stop the others, the run is recorded as a
+// snapshot of the directory at the end.
+func BackupDirectory(ctx context.Context, root db.BackupRoot, opts DirBackupOptions) (DirBackupResult, error) {
+	result := DirBackupResult{Snapshot: db.Snapshot{RootID: root.ID, Name: opts.Name}}
+
+	scanned, failed, err := scanDirectory(root.Path, opts.Exclude)
+	if err != nil {
+		return result, fmt.Errorf("error reading %s: %w", root.Path, err)
+	}
+	result.Files = append(result.Files, failed...)
+
+	entries, err := db.Default.BackupEntries(ctx, root.ID)
+	if err != nil {
+		return result, err
+	}
+	// Versions deleted or trashed since the last run are uploaded again
+	files, err := db.Default.ListFiles(ctx, db.FileFilter{AllVersions: true})
+	if err != nil {
+		return result, err
+	}
+	active := make(map[int]bool, len(files))
+	for _, f := range files {
+		active[f.ID] = true
+	}
+
+	seen := make(map[string]bool, len(scanned))
+	var uploads []scannedFile
+	var uploadIndexes []int
+	for _, s := range scanned {
+		seen[s.rel] = true
+		entry, known := entries[s.rel]
+		live := known && entry.FileID != 0 && active[entry.FileID]
+		file := BackedUpFile{Path: s.rel, Size: s.info.Size(), FileID: entry.FileID}
+
+		if live && entry.Removed.IsZero() && entry.Size == s.info.Size() && entry.ModTime.Equal(s.info.ModTime()) {
+			file.Status = BackupUnchanged
+			result.Files = append(result.Files, file)
+			continue
+		}
+
+		hash, err := hashPath(ctx, s.path)
+		if err != nil {
+			file.Status, file.Err = BackupFailed, err
+			result.Files = append(result.Files, file)
+			continue
+		}
+		if live && hash == entry.Hash {
+			// Only the modification time changed, remember it so the file isn't read next time
+			file.Status = BackupUnchanged
+			if !opts.DryRun {
+				entry.Size, entry.ModTime = s.info.Size(), s.info.ModTime()
+				if err := db.Default.SaveBackupEntry(ctx, root.ID, entry); err != nil {
+					file.Status, file.Err = BackupFailed, err
+				}
+			}
+			result.Files = append(result.Files, file)
+			continue
+		}
+
+		file.Status = BackupAdded
+		if live {
+			file.Status = BackupChanged
+		}
+		result.Files = append(result.Files, file)
+		uploads = append(uploads, s)
+		uploadIndexes = append(uploadIndexes, len(result.Files)-1)
+	}
+
+	var removed []string
+	for p, entry := range entries {
+		if !seen[p] && entry.Removed.IsZero() && opts.MarkRemoved && !insideFailed(p, failed) {
+			removed = append(removed, p)
+			result.Files = append(result.Files, BackedUpFile{Path: p, Size: entry.Size, Status: BackupRemoved, FileID: entry.FileID})
+		}
+	}
+
+	if !opts.DryRun {
+		jobs := make([]Job, len(uploads))
+		for i, s := range uploads {
+			jobs[i] = func() error {
+				fileID, err := uploadBackupFile(ctx, root, s)
+				result.Files[uploadIndexes[i]].FileID = fileID
+				return err
+			}
+		}
+		for i, err := range NewExecutor(opts.Workers).Run(jobs) {
+			if err != nil {
+				result.Files[uploadIndexes[i]].Status = BackupFailed
+				result.Files[uploadIndexes[i]].Err = err
+			}
+		}
+
+		if err := db.Default.MarkRemoved(ctx, root.ID, removed); err != nil {
+			return result, err
+		}
+	}
+
+	sort.Slice(result.Files, func(i, j int) bool { return result.Files[i].Path < result.Files[j].Path })
+	for _, f := range result.Files {
+		switch f.Status {
+		case BackupAdded:
+			result.Snapshot.Added++
+		case BackupChanged:
+			result.Snapshot.Changed++
+		case BackupUnchanged:
+			result.Snapshot.Unchanged++
+		case BackupRemoved:
+			result.Snapshot.Removed++
+		case BackupFailed:
+			result.Snapshot.Failed++
+		}
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+	return result, db.Default.CreateSnapshot(ctx, &result.Snapshot)
+}
+
+// uploadBackupFile uploads a file of the directory and records it as the current state of its path
+func uploadBackupFile(ctx context.Context, root db.BackupRoot, s scannedFile) (int, error) {
+	fmt.Fprintf(db.Messages, "Uploading %s\n", s.rel)
+	id, err := UploadAs(s.path, s.rel, root.GroupID)
+	if err != nil {
+		return 0, err
+	}
+	// The hash of the upload is the one of the content that was actually sent
+	file, err := db.Default.GetFile(ctx, int(id))
+	if err != nil {
+		return int(id), err
+	}
+	entry := db.BackupEntry{Path: s.rel, Size: s.info.Size(), ModTime: s.info.ModTime(), Hash: file.Hash, FileID: int(id)}
+	return int(id), db.Default.SaveBackupEntry(ctx, root.ID, entry)
+}
+
+// scanDirectory lists the regular files below dir that no exclude pattern matches, the database
+// directory is always left out. Files and directories below dir that can't be read are returned as failed.
+func scanDirectory(dir string, exclude []string) ([]scannedFile, []BackedUpFile, error) {
+	var files []scannedFile
+	var failed []BackedUpFile
+	dataDir, _ := filepath.Abs(db.DataDir)
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			failed = append(failed, BackedUpFile{Path: rel, Status: BackupFailed, Err: err})
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if p == dir {
+			return nil
+		}
+
+		if excluded(rel, exclude) || (d.IsDir() && p == dataDir) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// Symbolic links, devices and the like aren't backed up
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			failed = append(failed, BackedUpFile{Path: rel, Status: BackupFailed, Err: err})
+			return nil
+		}
+		files = append(files, scannedFile{rel: rel, path: p, info: info})
+		return nil
+	})
+	return files, failed, err
+}
+
+// insideFailed reports whether the path is one of the failed ones or inside a directory that couldn't be read,
+// such files may still exist
+func insideFailed(rel string, failed []BackedUpFile) bool {
+	for _, f := range failed {
+		if rel == f.Path || strings.HasPrefix(rel, f.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether a pattern matches the relative path or its last element
+func excluded(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hashPath returns the SHA-256 of the file at p
+func hashPath(ctx context.Context, p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return FileHash(ctx, file)
+}
@@ -2,51 +2,189 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/AnkanNandi/disvault/app"
 	"github.com/AnkanNandi/disvault/db"
 )
 
-// Assembles the binary files in one big file
-func DownloadAndReassembleFile(fileID int, outputFileName string) error {
+// OverwritePolicy decides what happens when the output path of a download already exists
+type OverwritePolicy int
+
+const (
+	FailIfExists OverwritePolicy = iota // Return an error and leave the existing file alone
+	Overwrite                           // Replace the existing file
+	Skip                                // Don't download the file at all, returns ErrSkipped
+	Rename                              // Save as "name (1).ext", "name (2).ext", ... instead
+)
+
+// DownloadOptions changes how a file is saved by DownloadAndReassembleFile
+type DownloadOptions struct {
+	Policy   OverwritePolicy // What happens when the output file already exists
+	Preserve bool            // Restore the modification time and permissions recorded at upload
+}
+
+// ErrSkipped is returned when a download was skipped because of the Skip policy
+var ErrSkipped = errors.New("output file already exists, skipped")
+
+// Paths handed out to downloads of this run, so two files with the same name
+// downloaded together don't end up writing to the same path
+var (
+	claimedMu sync.Mutex
+	claimed   = make(map[string]bool)
+)
+
+// DefaultOutputPath is where a file is saved when no output path is given
+func DefaultOutputPath(fileName string) string {
+	return filepath.Join(".", "out", fileName)
+}
+
+/*
+Assembles the binary files in one big file at outputPath and returns the path the file was saved to,
+which differs from outputPath when the Rename policy picked a new name.
+
+The parts are written to a temporary file next to the output which is only renamed
+to the final name once every part is downloaded, so a failed download never leaves a truncated file behind.
+*/
+func DownloadAndReassembleFile(fileID int, outputPath string, opts DownloadOptions) (savedPath string, err error) {
 	ctx := context.Background()
-	partIDs, err := db.GetPartIDs(ctx, fileID)
+	partIDs, err := db.Default.PartIDs(ctx, fileID)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve part IDs: %w", err)
+		return "", fmt.Errorf("failed to retrieve part IDs: %w", err)
 	}
 
 	// Create the output directory
-	outputDir := filepath.Join(".", "out")
+	outputDir := filepath.Dir(outputPath)
 	err = os.MkdirAll(outputDir, 0755)
 	if err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+		return "", fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Create the output file
-	outputFilePath := filepath.Join(outputDir, outputFileName)
-	outFile, err := os.Create(outputFilePath)
+	outputFilePath, err := claimOutputPath(outputPath, opts.Policy)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return "", err
 	}
+	defer func() {
+		// Successful downloads keep their path so later files of the same run can't overwrite them
+		if err != nil {
+			releaseOutputPath(outputFilePath)
+		}
+	}()
+
+	// Create the temporary output file
+	outFile, err := os.CreateTemp(outputDir, "."+filepath.Base(outputFilePath)+".*.part")
+	if err != nil {
+		return "", fmt.Errorf("failed to create output file: %w", err)
+	}
+	tempPath := outFile.Name()
+	// Once renamed the temp file doesn't exist anymore, so this only cleans up failed downloads
+	defer os.Remove(tempPath)
 	defer outFile.Close()
 
 	// Download and stitch each part together
 	for i, partID := range partIDs {
-		fmt.Printf("Downloading part %d/%d: %s\n", i+1, len(partIDs), partID)
+		fmt.Fprintf(db.Messages, "Downloading part %d/%d: %s\n", i+1, len(partIDs), partID)
 		partData, err := app.DownloadPart(partID)
 		if err != nil {
-			return fmt.Errorf("failed to download part %s: %w", partID, err)
+			return "", fmt.Errorf("failed to download part %s: %w", partID, err)
 		}
 
 		_, err = outFile.Write(partData)
 		if err != nil {
-			return fmt.Errorf("failed to write part %s to output file: %w", partID, err)
+			return "", fmt.Errorf("failed to write part %s to output file: %w", partID, err)
+		}
+	}
+
+	if err := outFile.Sync(); err != nil {
+		return "", fmt.Errorf("failed to flush output file: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return "", fmt.Errorf("failed to close output file: %w", err)
+	}
+	if opts.Preserve {
+		if err := restoreModeAndTime(ctx, fileID, tempPath); err != nil {
+			return "", err
 		}
 	}
+	if err := os.Rename(tempPath, outputFilePath); err != nil {
+		return "", fmt.Errorf("failed to move output file in place: %w", err)
+	}
+
+	fmt.Fprintf(db.Messages, "Successfully reassembled file to %s\n", outputFilePath)
+	return outputFilePath, nil
+}
 
-	fmt.Printf("Successfully reassembled file to %s\n", outputFilePath)
+// restoreModeAndTime applies the permissions and modification time recorded at upload to path,
+// files uploaded before those were recorded are left as they are
+func restoreModeAndTime(ctx context.Context, fileID int, path string) error {
+	mode, modTime, ok, err := db.Default.FileModeAndTime(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Fprintf(db.Messages, "No permissions or modification time recorded for file %d, keeping the defaults\n", fileID)
+		return nil
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to restore permissions: %w", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		return fmt.Errorf("failed to restore modification time: %w", err)
+	}
 	return nil
 }
+
+// claimOutputPath applies the overwrite policy to path and reserves the resulting path for this download
+func claimOutputPath(path string, policy OverwritePolicy) (string, error) {
+	claimedMu.Lock()
+	defer claimedMu.Unlock()
+
+	taken := func(p string) bool {
+		if claimed[p] {
+			return true
+		}
+		_, err := os.Lstat(p)
+		return err == nil
+	}
+
+	if !taken(path) {
+		claimed[path] = true
+		return path, nil
+	}
+
+	switch {
+	case policy == Rename:
+		ext := filepath.Ext(path)
+		base := strings.TrimSuffix(path, ext)
+		for i := 1; ; i++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+			if !taken(candidate) {
+				claimed[candidate] = true
+				return candidate, nil
+			}
+		}
+	case claimed[path]:
+		// Overwriting a file from the same run would silently lose one of them
+		return "", fmt.Errorf("another file of this download is already saved as %s, use rename to keep both", path)
+	case policy == Overwrite:
+		claimed[path] = true
+		return path, nil
+	case policy == Skip:
+		return "", ErrSkipped
+	default:
+		return "", fmt.Errorf("output file %s already exists, choose to overwrite, skip or rename it", path)
+	}
+}
+
+// releaseOutputPath frees a path reserved by claimOutputPath
+func releaseOutputPath(path string) {
+	claimedMu.Lock()
+	defer claimedMu.Unlock()
+	delete(claimed, path)
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Default settings for the Executor, small numbers on purpose so a single bot stays well within Discord's limits
+const (
+	DefaultWorkers    = 4
+	defaultMaxRetries = 5
+	defaultBackoff    = 2 * time.Second
+)
+
+// Job is a single unit of work handed to the Executor, i.e. deleting or downloading one file
+type Job func() error
+
+// Executor runs jobs on a fixed number of workers.
+// When a job fails because Discord rate limited us, every worker pauses until the
+// retry time has passed and the job is retried, other errors are returned as they are.
+type Executor struct {
+	Workers    int // Number of jobs running at the same time, defaults to DefaultWorkers
+	MaxRetries int // How many times a rate limited job is retried before giving up
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+// NewExecutor returns an Executor with the given amount of workers
+func NewExecutor(workers int) *Executor {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	return &Executor{Workers: workers, MaxRetries: defaultMaxRetries}
+}
+
+// Run executes all jobs and returns their errors in the same order as the jobs, nil means the job succeeded
+func (e *Executor) Run(jobs []Job) []error {
+	errs := make([]error, len(jobs))
+	indexes := make(chan int)
+
+	workers := e.Workers
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = e.runJob(jobs[i])
+			}
+		}()
+	}
+
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
+
+// runJob runs a single job, retrying it as long as it is rate limited
+func (e *Executor) runJob(job Job) error {
+	var err error
+	for attempt := 0; attempt <= e.MaxRetries; attempt++ {
+		e.waitForPause()
+
+		err = job()
+		retryAfter, limited := rateLimited(err)
+		if !limited {
+			return err
+		}
+		e.pause(retryAfter)
+	}
+	return err
+}
+
+// waitForPause blocks while a rate limit reported by any worker is still active
+func (e *Executor) waitForPause() {
+	e.mu.Lock()
+	wait := time.Until(e.pauseUntil)
+	e.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// pause stops all workers from starting new jobs for the given duration
+func (e *Executor) pause(d time.Duration) {
+	if d <= 0 {
+		d = defaultBackoff
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if until := time.Now().Add(d); until.After(e.pauseUntil) {
+		e.pauseUntil = until
+	}
+}
+
+// rateLimited reports whether err is caused by a Discord rate limit and how long to wait before retrying
+func rateLimited(err error) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	var rlErr *discordgo.RateLimitError
+	if errors.As(err, &rlErr) {
+		if rlErr.RateLimit != nil && rlErr.TooManyRequests != nil {
+			return rlErr.RetryAfter, true
+		}
+		return defaultBackoff, true
+	}
+
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusTooManyRequests {
+		return defaultBackoff, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/AnkanNandi/disvault/app"
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/bwmarrin/discordgo"
+)
+
+// OrphanMessage is a message of the bot holding an attachment that no registered part points to,
+// left behind by an upload that crashed before the file was registered or a delete that stopped midway
+type OrphanMessage struct {
+	MessageID string
+	FileUUID  string // From the part header, empty for messages without one
+	Index     int    // Position of the part, from the part header
+	Hash      string // SHA-256 of the part, from the part header
+	Size      int64  // Size of the attachment in bytes
+}
+
+// DanglingPart is a registered part whose message isn't in the channel anymore
+type DanglingPart struct {
+	FileID    int
+	FileName  string
+	MessageID string
+}
+
+// IncompleteFile is a registered file that has fewer parts than it was split into
+type IncompleteFile struct {
+	FileID   int
+	FileName string
+	Parts    int       // Parts left once the dangling parts are removed
+	Total    int       // Parts the file was split into
+	Repair   []db.Part // Orphan messages holding the missing parts, set when they complete the file
+}
+
+// GarbageReport is what ScanGarbage found
+type GarbageReport struct {
+	Scanned     int // Messages read from the channel
+	Orphans     []OrphanMessage
+	Recoverable int // Files the rebuild command can register again from orphan messages, these are left alone
+	Recent      int // Messages without a registered part younger than the minimum age, left alone
+	Dangling    []DanglingPart
+	Incomplete  []IncompleteFile
+}
+
+// DefaultGCMinAge is how old a message without a registered part has to be before gc takes it for an orphan
+const DefaultGCMinAge = time.Hour
+
+// ScanGarbage reads the whole channel and compares it with the parts table. Orphan messages that
+// together make up a whole file aren't reported as orphans but counted as Recoverable, and orphans
+// holding the missing parts of an incomplete file are used to repair it. Messages posted less than
+// minAge ago are only counted as Recent, they may be parts of an upload that isn't registered yet.
+func ScanGarbage(ctx context.Context, minAge time.Duration) (GarbageReport, error) {
+	bot, err := app.Session.User("@me")
+	if err != nil {
+		return GarbageReport{}, fmt.Errorf("error fetching the bot user: %w", err)
+	}
+	return scanGarbage(ctx, minAge, bot.ID, app.ScanChannel)
+}
+
+// scanGarbage is ScanGarbage reading the channel with scan, botID is the user the parts are posted as
+func scanGarbage(ctx context.Context, minAge time.Duration, botID string, scan func(fn func([]*discordgo.Message) error) error) (GarbageReport, error) {
+	var report GarbageReport
+
+	// The database is read before the channel. The scan reads newest first and never sees messages
+	// posted after it started, so files registered meanwhile would look like they lost all their parts.
+	known, err := db.Default.KnownPartIDs(ctx)
+	if err != nil {
+		return report, err
+	}
+	files, err := db.Default.ListFiles(ctx, db.FileFilter{States: db.FileStates, AllVersions: true})
+	if err != nil {
+		return report, err
+	}
+	parts, err := db.Default.PartsByFile(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	inChannel := make(map[string]bool)
+	var orphans []OrphanMessage
+	totals := make(map[string]int) // Number of parts of the files of orphan parts, by file UUID
+	err = scan(func(messages []*discordgo.Message) error {
+		for _, m := range messages {
+			report.Scanned++
+			inChannel[m.ID] = true
+			if known[m.ID] || len(m.Attachments) == 0 || m.Author == nil || m.Author.ID != botID || IsBackupMessage(m.Content) {
+				continue
+			}
+			if posted, err := discordgo.SnowflakeTimestamp(m.ID); err != nil || posted.After(cutoff) {
+				report.Recent++
+				continue
+			}
+			orphan := OrphanMessage{MessageID: m.ID, Size: int64(m.Attachments[0].Size)}
+			if header, ok, err := ParseHeader(m.Content); ok && err == nil {
+				orphan.FileUUID, orphan.Index, orphan.Hash = header.FileUUID, header.Index, header.Hash
+				totals[header.FileUUID] = header.Total
+			}
+			orphans = append(orphans, orphan)
+		}
+		fmt.Fprintf(db.Messages, "Scanned %d message(s)...\n", report.Scanned)
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("error reading the channel: %w", err)
+	}
+
+	// Orphan parts by file UUID and index, the history is read newest first so the oldest copy wins
+	orphanParts := make(map[string]map[int]OrphanMessage)
+	for _, o := range orphans {
+		if o.FileUUID == "" {
+			continue
+		}
+		if orphanParts[o.FileUUID] == nil {
+			orphanParts[o.FileUUID] = make(map[int]OrphanMessage)
+		}
+		orphanParts[o.FileUUID][o.Index] = o
+	}
+
+	used := make(map[string]bool) // Orphans that repair a file or belong to a recoverable one
+	registered := make(map[string]bool)
+	for _, f := range files {
+		registered[f.UUID] = true
+		present := make(map[int]bool)
+		for _, p := range parts[f.ID] {
+			if !inChannel[p.MessageID] {
+				report.Dangling = append(report.Dangling, DanglingPart{FileID: f.ID, FileName: f.Name, MessageID: p.MessageID})
+				continue
+			}
+			present[p.Index] = true
+		}
+		if len(present) >= f.Total_parts {
+			continue
+		}
+
+		incomplete := IncompleteFile{FileID: f.ID, FileName: f.Name, Parts: len(present), Total: f.Total_parts}
+		var repair []db.Part
+		for i := 0; i < f.Total_parts; i++ {
+			if present[i] {
+				continue
+			}
+			o, ok := orphanParts[f.UUID][i]
+			if !ok {
+				repair = nil
+				break
+			}
+			repair = append(repair, db.Part{MessageID: o.MessageID, Index: i, Size: o.Size, Hash: o.Hash})
+		}
+		if repair != nil {
+			incomplete.Repair = repair
+			for _, p := range repair {
+				used[p.MessageID] = true
+			}
+		}
+		report.Incomplete = append(report.Incomplete, incomplete)
+	}
+
+	// Complete files that aren't registered at all are left for rebuild
+	for uuid, found := range orphanParts {
+		if registered[uuid] || len(found) < totals[uuid] {
+			continue
+		}
+		report.Recoverable++
+		for _, o := range found {
+			used[o.MessageID] = true
+		}
+	}
+
+	for _, o := range orphans {
+		if !used[o.MessageID] {
+			report.Orphans = append(report.Orphans, o)
+		}
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].MessageID < report.Orphans[j].MessageID })
+	return report, nil
+}
+
+// CollectGarbage cleans up what ScanGarbage found: dangling parts are removed from the database,
+// incomplete files are repaired when the missing parts were found and deleted otherwise,
+// and orphan messages are deleted from the channel. It stops at the first error.
+func CollectGarbage(ctx context.Context, report GarbageReport) error {
+	dangling := make(map[int][]string)
+	for _, d := range report.Dangling {
+		dangling[d.FileID] = append(dangling[d.FileID], d.MessageID)
+	}
+	for fileID, partIDs := range dangling {
+		if err := db.Default.DeleteParts(ctx, fileID, partIDs, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(db.Messages, "Removed %d dangling part(s) of file ID %d\n", len(partIDs), fileID)
+	}
+
+	for _, f := range report.Incomplete {
+		if f.Repair != nil {
+			if err := db.Default.AddParts(ctx, f.FileID, f.Repair); err != nil {
+				return err
+			}
+			fmt.Fprintf(db.Messages, "Repaired file ID %d with %d part(s) found in the channel\n", f.FileID, len(f.Repair))
+			continue
+		}
+		if err := DeleteFileParts(f.FileID); err != nil {
+			return fmt.Errorf("failed to delete incomplete file %d: %w", f.FileID, err)
+		}
+	}
+
+	for _, o := range report.Orphans {
+		err := app.Session.ChannelMessageDelete(app.Config.ChannelID, o.MessageID)
+		if err != nil && !app.IsUnknownMessage(err) {
+			return fmt.Errorf("failed to delete message %s from Discord: %w", o.MessageID, err)
+		}
+		fmt.Fprintf(db.Messages, "Deleted orphan message %s\n", o.MessageID)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/bwmarrin/discordgo"
+)
+
+const testBotID = "42"
+
+// useTestDatabase points db.Default to a migrated in-memory database for the test
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	oldDefault, oldMessages := db.Default, db.Messages
+	db.Messages = io.Discard
+	t.Cleanup(func() { db.Default, db.Messages = oldDefault, oldMessages })
+
+	conn, err := sql.Open("sqlite", "file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+	db.Default = db.NewStore(conn)
+	if _, _, err := db.Default.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+}
+
+// snowflake returns a message ID posted at the time
+func snowflake(at time.Time) string {
+	return fmt.Sprint((at.UnixMilli() - 1420070400000) << 22)
+}
+
+// registerPart registers a file with a single part posted in the message
+func registerPart(t *testing.T, name, messageID string) int {
+	t.Helper()
+	file := &db.FilesLocal{Name: name, Total_parts: 1, Size: 10, Hash: name, GroupID: 1}
+	parts := &db.Parts{Parts: []db.Part{{MessageID: messageID, Size: 10}}}
+	id, err := db.Default.RegisterFile(context.Background(), file, parts)
+	if err != nil {
+		t.Fatalf("RegisterFile %s: %v", name, err)
+	}
+	return int(id)
+}
+
+func TestScanGarbageIgnoresFilesRegisteredDuringScan(t *testing.T) {
+	useTestDatabase(t)
+	old := snowflake(time.Now().Add(-48 * time.Hour))
+	registerPart(t, "kept.txt", old)
+	lost := registerPart(t, "lost.txt", snowflake(time.Now().Add(-47*time.Hour)))
+
+	// An upload registers its file while the channel is read, after the scan fetched the newest messages
+	scan := func(fn func([]*discordgo.Message) error) error {
+		registerPart(t, "new.txt", snowflake(time.Now()))
+		return fn([]*discordgo.Message{{ID: old, Author: &discordgo.User{ID: testBotID}, Attachments: []*discordgo.MessageAttachment{{Size: 10}}}})
+	}
+	report, err := scanGarbage(context.Background(), DefaultGCMinAge, testBotID, scan)
+	if err != nil {
+		t.Fatalf("scanGarbage: %v", err)
+	}
+
+	if len(report.Dangling) != 1 || report.Dangling[0].FileID != lost {
+		t.Errorf("dangling parts are %+v, want only the part of file %d", report.Dangling, lost)
+	}
+	if len(report.Incomplete) != 1 || report.Incomplete[0].FileID != lost {
+		t.Errorf("incomplete files are %+v, want only file %d", report.Incomplete, lost)
+	}
+	if len(report.Orphans) != 0 {
+		t.Errorf("orphans are %+v, want none", report.Orphans)
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// headerPrefix starts the content of every part message, followed by the header as JSON
+const headerPrefix = "disvault:"
+
+// uuidPattern matches the UUIDs of db.NewUUID, a header may come from anyone who can post in the
+// channel and its UUID ends up in the names of recovered files
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// PartHeader is written into the message of every uploaded part, so the files can be put back
+// together from the channel alone when the database is lost. The short field names keep the
+// message well under Discord's 2000 character limit.
+type PartHeader struct {
+	Version       int    `json:"v"`
+	FileUUID      string `json:"id"`              // Same for every part of a file
+	Index         int    `json:"i"`               // Position of the chunk, starting at 0
+	Total         int    `json:"n"`               // Number of parts of the file
+	Hash          string `json:"h"`               // SHA-256 of the chunk
+	Size          int64  `json:"s"`               // Size of the chunk in bytes
+	FileHash      string `json:"fh"`              // SHA-256 of the whole file
+	FileSize      int64  `json:"fs"`              // Size of the whole file in bytes
+	Name          string `json:"name,omitempty"`  // File name, unless it is encrypted
+	EncryptedName string `json:"ename,omitempty"` // File name encrypted with the configured key
+}
+
+// headerVersion is the version of PartHeader written by this build
+const headerVersion = 1
+
+// Encode returns the message content holding the header
+func (h PartHeader) Encode() (string, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode part header: %w", err)
+	}
+	return headerPrefix + string(data), nil
+}
+
+// ParseHeader reads the header of a part message, ok is false for messages without one,
+// i.e. parts uploaded before headers existed or anything else posted in the channel
+func ParseHeader(content string) (header PartHeader, ok bool, err error) {
+	if !strings.HasPrefix(content, headerPrefix) {
+		return header, false, nil
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(content, headerPrefix)), &header); err != nil {
+		return header, true, fmt.Errorf("invalid part header: %w", err)
+	}
+	if !uuidPattern.MatchString(header.FileUUID) || header.Total <= 0 || header.Index < 0 || header.Index >= header.Total {
+		return header, true, errors.New("invalid part header: missing or out of range fields")
+	}
+	return header, true, nil
+}
+
+// FileName returns the name in the header, decrypting it with key if it is encrypted
+func (h PartHeader) FileName(key string) (string, error) {
+	if h.EncryptedName == "" {
+		return h.Name, nil
+	}
+	if key == "" {
+		return "", errors.New("the file name is encrypted and no encryption key is configured")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(h.EncryptedName)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted file name: %w", err)
+	}
+	name, err := Decrypt(key, data)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// SetFileName puts the name into the header, encrypted when a key is given
+func (h *PartHeader) SetFileName(name, key string) error {
+	if key == "" {
+		h.Name = name
+		return nil
+	}
+	data, err := Encrypt(key, []byte(name))
+	if err != nil {
+		return err
+	}
+	h.EncryptedName = base64.RawURLEncoding.EncodeToString(data)
+	return nil
+}
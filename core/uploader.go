@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/AnkanNandi/disvault/app"
 	"github.com/AnkanNandi/disvault/db"
@@ -15,77 +19,131 @@ import (
 
 const chunkSize = 25 * 1024 * 1024 // 25 MB
 
-func Upload(inputFile string, groupID int) error {
+// Upload splits the input file into chunks, uploads them and registers the file in the database.
+// It returns the ID the file was registered with.
+func Upload(inputFile string, groupID int) (int64, error) {
+	return UploadAs(inputFile, "", groupID)
+}
+
+// UploadAs uploads the input file like Upload but registers it under name, the base name of the file when empty
+func UploadAs(inputFile, name string, groupID int) (int64, error) {
 	ctx := context.Background()
 
 	// Create a temporary directory for file chunks
 	tempDir, err := os.MkdirTemp("", "temp")
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return 0, fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
 	// Open the input file
 	file, err := os.Open(inputFile)
 	if err != nil {
-		return fmt.Errorf("failed to open input file: %w", err)
+		return 0, fmt.Errorf("failed to open input file: %w", err)
 	}
 	defer file.Close()
 
 	// Retrieve file info
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+		return 0, fmt.Errorf("failed to get file info: %w", err)
 	}
 
+	// Sniff the content type from the start of the file
+	mimeType, err := DetectMimeType(file, fileInfo.Name())
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect file type: %w", err)
+	}
+
+	absPath, err := filepath.Abs(inputFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve file path: %w", err)
+	}
+
+	// A missing host name shouldn't stop the upload
+	host, _ := os.Hostname()
+
 	// Calculate file hash
 	fileHash, err := FileHash(ctx, file)
 	if err != nil {
-		return fmt.Errorf("failed to calculate file hash: %w", err)
+		return 0, fmt.Errorf("failed to calculate file hash: %w", err)
 	}
 
 	// Reset file pointer
 	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to reset file pointer: %w", err)
+		return 0, fmt.Errorf("failed to reset file pointer: %w", err)
+	}
+
+	if name == "" {
+		name = fileInfo.Name()
 	}
 
-	// Register the main file in the database
+	// The file is registered together with its parts once all of them are uploaded
 	fileToBeUploaded := db.FilesLocal{
-		Name:        fileInfo.Name(),
+		Name:        name,
 		Total_parts: FilePartsCalc(fileInfo.Size()),
 		Size:        fileInfo.Size(),
 		Hash:        fileHash,
 		GroupID:     groupID,
-	}
-
-	mainFileID, err := db.RegisterFileEntry(ctx, &fileToBeUploaded)
-	if err != nil {
-		return fmt.Errorf("failed to register file in database: %w", err)
+		UUID:        db.NewUUID(),
+
+		UploadedAt:   time.Now(),
+		ModTime:      fileInfo.ModTime(),
+		Mode:         fileInfo.Mode().Perm(),
+		MimeType:     mimeType,
+		OriginalPath: absPath,
+		Host:         host,
 	}
 
 	// Buffer for reading file chunks
 	buffer := make([]byte, chunkSize)
-	fileParts := db.Parts{FileID: int(mainFileID)}
+	var fileParts db.Parts
 
 	// Read and upload chunks
 	for i := 0; ; i++ {
 		bytesRead, err := file.Read(buffer)
 		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading file chunk: %w", err)
+			return 0, fmt.Errorf("error reading file chunk: %w", err)
 		}
 		if bytesRead == 0 {
 			break
 		}
 
-		// Write chunk to temporary file
-		chunkPath := filepath.Join(tempDir, fmt.Sprintf("%s.part%d", fileInfo.Name(), i))
+		// Write chunk to temporary file, the file name isn't given away in the attachment when names are encrypted
+		chunkName := fileInfo.Name()
+		if app.Config.EncryptionKey != "" {
+			chunkName = fileToBeUploaded.UUID
+		}
+		chunkPath := filepath.Join(tempDir, fmt.Sprintf("%s.part%d", chunkName, i))
 		if err := os.WriteFile(chunkPath, buffer[:bytesRead], 0644); err != nil {
-			return fmt.Errorf("error writing chunk file: %w", err)
+			return 0, fmt.Errorf("error writing chunk file: %w", err)
 		}
 
 		// Upload chunk
-		if _, err := app.UploadFile(ctx, chunkPath, &fileParts); err != nil {
-			return fmt.Errorf("error uploading chunk: %w", err)
+		part := db.Part{
+			Index: i,
+			Size:  int64(bytesRead),
+			Hash:  fmt.Sprintf("%x", sha256.Sum256(buffer[:bytesRead])),
+		}
+		header := PartHeader{
+			Version:  headerVersion,
+			FileUUID: fileToBeUploaded.UUID,
+			Index:    part.Index,
+			Total:    fileToBeUploaded.Total_parts,
+			Hash:     part.Hash,
+			Size:     part.Size,
+			FileHash: fileHash,
+			FileSize: fileToBeUploaded.Size,
+		}
+		if err := header.SetFileName(fileToBeUploaded.Name, app.Config.EncryptionKey); err != nil {
+			return 0, fmt.Errorf("error encrypting file name: %w", err)
+		}
+		content, err := header.Encode()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := app.UploadFile(ctx, chunkPath, content, part, &fileParts); err != nil {
+			return 0, fmt.Errorf("error uploading chunk: %w", err)
 		}
 
 		// Stop if at the end of file
@@ -94,13 +152,14 @@ func Upload(inputFile string, groupID int) error {
 		}
 	}
 
-	// Insert file parts into the database
-	if err := fileParts.InsertParts(ctx, fileParts); err != nil {
-		return fmt.Errorf("error inserting file parts into database: %w", err)
+	// Register the file and its parts in the database
+	mainFileID, err := db.Default.RegisterFile(ctx, &fileToBeUploaded, &fileParts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register file in database: %w", err)
 	}
 
-	fmt.Println("File upload completed successfully.")
-	return nil
+	fmt.Fprintln(db.Messages, "File upload completed successfully.")
+	return mainFileID, nil
 }
 
 // FilePartsCalc calculates the number of parts required to split the file.
@@ -108,6 +167,23 @@ func FilePartsCalc(fileSize int64) int {
 	return int(math.Ceil(float64(fileSize) / chunkSize))
 }
 
+// DetectMimeType sniffs the content type from the first 512 bytes of the file.
+// When the content is too generic to tell (plain text, unknown binary) the extension decides if it is a known one.
+func DetectMimeType(file *os.File, name string) (string, error) {
+	buffer := make([]byte, 512)
+	n, err := file.ReadAt(buffer, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	sniffed := http.DetectContentType(buffer[:n])
+	generic := strings.HasPrefix(sniffed, "application/octet-stream") || strings.HasPrefix(sniffed, "text/plain")
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); generic && byExt != "" {
+		return byExt, nil
+	}
+	return sniffed, nil
+}
+
 // FileHash calculates the SHA-256 hash of the given file.
 func FileHash(ctx context.Context, file *os.File) (string, error) {
 	h := sha256.New()
@@ -0,0 +1,297 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AnkanNandi/disvault/db"
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultSettle is how long a file has to stay unchanged before the watcher uploads it
+const DefaultSettle = 2 * time.Second
+
+// WatchOptions changes what Watch does
+type WatchOptions struct {
+	GroupID   int
+	Recursive bool          // Also watch the subdirectories, files in them are named by their path relative to the directory
+	Settle    time.Duration // How long a file has to stay unchanged before it is uploaded, DefaultSettle when 0
+	Exclude   []string      // Glob patterns matched against the relative path and the name of files and directories
+	Workers   int           // Number of files uploaded at the same time
+
+	// OnUpload is called after every upload with the file ID or the error, from the upload workers
+	OnUpload func(rel string, size int64, fileID int64, err error)
+}
+
+// pendingFile is a file that changed and waits to be stable
+type pendingFile struct {
+	lastEvent time.Time
+	size      int64
+	modTime   time.Time
+}
+
+// watcher holds the state of a running Watch
+type watcher struct {
+	dir     string
+	opts    WatchOptions
+	fs      *fsnotify.Watcher
+	dataDir string
+
+	mu       sync.Mutex
+	pending  map[string]*pendingFile // By absolute path
+	inFlight map[string]bool         // Files queued or being uploaded
+	uploaded map[string]pendingFile  // Size and modification time of the last upload of a file
+
+	// Settled files waiting for an upload worker. The queue has no limit so the event loop never
+	// waits for uploads, fsnotify drops events when they aren't read in time.
+	queue   []string
+	ready   *sync.Cond // Signalled when the queue grows or the watch stops, uses mu
+	stopped bool
+}
+
+// Watch uploads the files created or modified in dir until ctx is done. Writes are debounced, a file
+// is only uploaded once its size and modification time didn't change for opts.Settle, so files still
+// being written or copied aren't uploaded halfway. Files that already exist when Watch starts are
+// left alone, as are hidden files and the database directory. Uploads that are running when ctx is
+// done are finished before Watch returns, settled files still waiting for a worker aren't uploaded.
+func Watch(ctx context.Context, dir string, opts WatchOptions) error {
+	if opts.Settle <= 0 {
+		opts.Settle = DefaultSettle
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error starting the file watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	dataDir, _ := filepath.Abs(filepath.Join(".", "data"))
+	w := &watcher{
+		dir:      dir,
+		opts:     opts,
+		fs:       fsw,
+		dataDir:  dataDir,
+		pending:  make(map[string]*pendingFile),
+		inFlight: make(map[string]bool),
+		uploaded: make(map[string]pendingFile),
+	}
+	w.ready = sync.NewCond(&w.mu)
+	if err := w.add(dir, false); err != nil {
+		return err
+	}
+
+	var workers sync.WaitGroup
+	executor := NewExecutor(opts.Workers)
+	for i := 0; i < executor.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				path, ok := w.next()
+				if !ok {
+					return
+				}
+				w.upload(executor, path)
+			}
+		}()
+	}
+	defer workers.Wait()
+	defer w.stop()
+
+	tick := time.NewTicker(max(opts.Settle/4, 100*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event)
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(db.Messages, "Warning: file watcher: %v\n", err)
+		case <-tick.C:
+			w.queueSettled()
+		}
+	}
+}
+
+// add watches dir, and its subdirectories when the watch is recursive. With queueFiles the files
+// already inside are treated as new, for directories that were created or moved in while watching.
+func (w *watcher) add(dir string, queueFiles bool) error {
+	if !w.opts.Recursive {
+		return w.fs.Add(dir)
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			fmt.Fprintf(db.Messages, "Warning: can't watch %s: %v\n", path, err)
+			return nil
+		}
+		if path != dir && w.ignored(path, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := w.fs.Add(path); err != nil {
+				fmt.Fprintf(db.Messages, "Warning: can't watch %s: %v\n", path, err)
+			}
+			return nil
+		}
+		if queueFiles {
+			w.touch(path)
+		}
+		return nil
+	})
+}
+
+// handle records a change reported by fsnotify
+func (w *watcher) handle(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		// Removed again before we got to it
+		return
+	}
+	if w.ignored(event.Name, info.IsDir()) {
+		return
+	}
+	if info.IsDir() {
+		if event.Has(fsnotify.Create) && w.opts.Recursive {
+			if err := w.add(event.Name, true); err != nil {
+				fmt.Fprintf(db.Messages, "Warning: can't watch %s: %v\n", event.Name, err)
+			}
+		}
+		return
+	}
+	w.touch(event.Name)
+}
+
+// touch marks the file as changed just now
+func (w *watcher) touch(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p, ok := w.pending[path]; ok {
+		p.lastEvent = time.Now()
+		return
+	}
+	w.pending[path] = &pendingFile{lastEvent: time.Now()}
+}
+
+// queueSettled moves the pending files that didn't change for the settle time to the upload queue.
+// A file whose size or modification time still changed since the last check waits longer.
+func (w *watcher) queueSettled() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queued := len(w.queue)
+	for path, p := range w.pending {
+		if w.inFlight[path] || time.Since(p.lastEvent) < w.opts.Settle {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			delete(w.pending, path)
+			continue
+		}
+		if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+			p.size, p.modTime, p.lastEvent = info.Size(), info.ModTime(), time.Now()
+			continue
+		}
+		delete(w.pending, path)
+		// Events that didn't change anything, i.e. a file opened for writing and closed again
+		if last, ok := w.uploaded[path]; ok && last.size == p.size && last.modTime.Equal(p.modTime) {
+			continue
+		}
+		w.inFlight[path] = true
+		w.queue = append(w.queue, path)
+	}
+	for range w.queue[queued:] {
+		w.ready.Signal()
+	}
+}
+
+// next takes the next file off the upload queue and waits for one while it is empty,
+// ok is false once the watch stopped. Queued files that weren't started are dropped then.
+func (w *watcher) next() (path string, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) == 0 && !w.stopped {
+		w.ready.Wait()
+	}
+	if w.stopped {
+		return "", false
+	}
+	path, w.queue = w.queue[0], w.queue[1:]
+	return path, true
+}
+
+// stop makes the upload workers return once they finished the file they are uploading
+func (w *watcher) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+	w.ready.Broadcast()
+}
+
+// upload uploads a settled file, named by its path relative to the watched directory so a
+// file uploaded again becomes a new version of the earlier upload
+func (w *watcher) upload(executor *Executor, path string) {
+	rel, _ := filepath.Rel(w.dir, path)
+	rel = filepath.ToSlash(rel)
+	info, statErr := os.Stat(path)
+	var size int64
+	if statErr == nil {
+		size = info.Size()
+	}
+
+	var fileID int64
+	err := executor.Run([]Job{func() error {
+		var err error
+		fileID, err = UploadAs(path, rel, w.opts.GroupID)
+		return err
+	}})[0]
+
+	w.mu.Lock()
+	delete(w.inFlight, path)
+	if err == nil && statErr == nil {
+		w.uploaded[path] = pendingFile{size: info.Size(), modTime: info.ModTime()}
+	}
+	w.mu.Unlock()
+
+	if w.opts.OnUpload != nil {
+		w.opts.OnUpload(rel, size, fileID, err)
+	}
+}
+
+// ignored reports whether changes to the path are left alone: hidden files, editor backups,
+// the database directory and whatever the exclude patterns match
+func (w *watcher) ignored(path string, isDir bool) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return true
+	}
+	if isDir && path == w.dataDir {
+		return true
+	}
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil {
+		return false
+	}
+	return excluded(filepath.ToSlash(rel), w.opts.Exclude)
+}
@@ -4,17 +4,30 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// Location of the database, relative to the working directory
 var (
-	DB   *sql.DB
-	once sync.Once
+	DataDir = "data"
+	Path    = filepath.Join(DataDir, "db.sql")
+)
+
+// Messages is where progress and status messages are written, by this package and the ones built on it.
+// Commands writing machine-readable results point it to stderr so stdout only carries the results.
+var Messages io.Writer = os.Stdout
+
+var (
+	once     sync.Once
+	initErr  error
+	openOnce sync.Once
+	openErr  error
 )
 
 // Groups table contains data for group so it can be joined when called by the user
@@ -23,6 +36,8 @@ var (
 // Parts table has all the parts that are uploaded by the UploadFiles function
 // On the groups table, root group means if a group is related to some other group,
 // TODO: add better names
+
+// Tables is the baseline schema, version 1 of the database, later changes are migrations in migrate.go.
 const Tables string = `-- Create the 'groups' table with a self-referencing foreign key
 			CREATE TABLE IF NOT EXISTS groups (
    			 group_id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -48,125 +63,168 @@ const Tables string = `-- Create the 'groups' table with a self-referencing fore
    			 size INTEGER NOT NULL,
    			 hash TEXT NOT NULL,
     		 group_id INTEGER NOT NULL DEFAULT 1,  -- Set default group to 'uncategorized'
+			 uploaded_at INTEGER,    -- Unix time of the upload
+			 modified_at INTEGER,    -- Unix modification time of the original file
+			 mode INTEGER,           -- Permission bits of the original file
+			 mime_type TEXT,
+			 original_path TEXT,     -- Absolute path of the file on the uploading machine
+			 uploader_host TEXT,     -- Host name of the uploading machine
     		 FOREIGN KEY (group_id) REFERENCES groups(group_id)
 			);
 
 			-- Create indexes for 'files'
 			CREATE INDEX IF NOT EXISTS idx_file_search_id ON files(group_id);
 			CREATE INDEX IF NOT EXISTS idx_file_search_name ON files(name);
+			CREATE INDEX IF NOT EXISTS idx_file_uploaded_at ON files(uploaded_at);
 
 			-- Create the 'parts' table
 			CREATE TABLE IF NOT EXISTS parts (
    			 part_id TEXT PRIMARY KEY,
    			 file_id INTEGER NOT NULL,
+			 part_index INTEGER,  -- Position of the chunk in the file, starting at 0
+			 size INTEGER,        -- Size of the chunk in bytes
+			 hash TEXT,           -- SHA-256 of the chunk
    			 FOREIGN KEY (file_id) REFERENCES files(id)
 			);
 
 			-- Create index for 'parts'
 			CREATE INDEX IF NOT EXISTS idx_file_id ON parts(file_id);
+
+			-- Create the 'file_notes' table, a free text description of a file
+			CREATE TABLE IF NOT EXISTS file_notes (
+			 file_id INTEGER PRIMARY KEY,
+			 note TEXT NOT NULL,
+			 FOREIGN KEY (file_id) REFERENCES files(id)
+			);
+
+			-- Create the 'tags' table and the 'file_tags' join table, a file may have any number of tags
+			CREATE TABLE IF NOT EXISTS tags (
+			 tag_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			 tag_name TEXT UNIQUE NOT NULL
+			);
+			CREATE TABLE IF NOT EXISTS file_tags (
+			 file_id INTEGER NOT NULL,
+			 tag_id INTEGER NOT NULL,
+			 PRIMARY KEY (file_id, tag_id),
+			 FOREIGN KEY (file_id) REFERENCES files(id),
+			 FOREIGN KEY (tag_id) REFERENCES tags(tag_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_file_tags_tag ON file_tags(tag_id);
+
+			-- Full-text index over the file names, notes and tags, the rowid is the file id
+			CREATE VIRTUAL TABLE IF NOT EXISTS files_fts USING fts5(name, notes, tags, tokenize = 'unicode61 remove_diacritics 2');
+
+			-- Keep 'files_fts' in sync with 'files' and 'file_notes'
+			CREATE TRIGGER IF NOT EXISTS files_fts_insert AFTER INSERT ON files BEGIN
+			 INSERT INTO files_fts (rowid, name, notes) VALUES (new.id, new.name, '');
+			END;
+			CREATE TRIGGER IF NOT EXISTS files_fts_rename AFTER UPDATE OF name ON files BEGIN
+			 UPDATE files_fts SET name = new.name WHERE rowid = new.id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS files_fts_delete AFTER DELETE ON files BEGIN
+			 DELETE FROM files_fts WHERE rowid = old.id;
+			 DELETE FROM file_notes WHERE file_id = old.id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS file_notes_fts_insert AFTER INSERT ON file_notes BEGIN
+			 UPDATE files_fts SET notes = new.note WHERE rowid = new.file_id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS file_notes_fts_update AFTER UPDATE ON file_notes BEGIN
+			 UPDATE files_fts SET notes = new.note WHERE rowid = new.file_id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS file_notes_fts_delete AFTER DELETE ON file_notes BEGIN
+			 UPDATE files_fts SET notes = '' WHERE rowid = old.file_id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS file_tags_fts_insert AFTER INSERT ON file_tags BEGIN
+			 UPDATE files_fts SET tags = (` + fileTagsSQL + ` WHERE ft.file_id = new.file_id) WHERE rowid = new.file_id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS file_tags_fts_delete AFTER DELETE ON file_tags BEGIN
+			 UPDATE files_fts SET tags = (` + fileTagsSQL + ` WHERE ft.file_id = old.file_id) WHERE rowid = old.file_id;
+			END;
+			CREATE TRIGGER IF NOT EXISTS files_tags_delete AFTER DELETE ON files BEGIN
+			 DELETE FROM file_tags WHERE file_id = old.id;
+			END;
+
+			-- Index the files that were uploaded before 'files_fts' existed
+			INSERT INTO files_fts (rowid, name, notes, tags)
+			SELECT f.id, f.name, COALESCE(n.note, ''), COALESCE((` + fileTagsSQL + ` WHERE ft.file_id = f.id), '')
+			FROM files f
+			LEFT JOIN file_notes n ON n.file_id = f.id
+			WHERE f.id NOT IN (SELECT rowid FROM files_fts);
 `
 
-// InitDatabase initializes the database, creating necessary tables if they don't exist.
-func InitDatabase() error {
-	var err error
+// fileTagsSQL selects the tags of a file separated by spaces, append the condition on ft.file_id
+const fileTagsSQL = `SELECT group_concat(t.tag_name, ' ') FROM file_tags ft JOIN tags t ON t.tag_id = ft.tag_id`
 
-	once.Do(func() {
-		// Create the output directory
-		dbPath := filepath.Join(".", "data")
-		err = os.MkdirAll(dbPath, 0755)
-		// Open the database connection
-		DB, err = sql.Open("sqlite", filepath.Join(dbPath, "db.sql"))
-		if err != nil {
-			err = fmt.Errorf("failed to open database: %w", err)
-			return
-		}
+// groupPathsSQL is a recursive common table expression named group_paths holding the full path
+// of every group, i.e. projects/alpha for the group alpha inside projects.
+// Use it as `WITH RECURSIVE ` + groupPathsSQL + ` SELECT ...` and join on group_paths.group_id.
+const groupPathsSQL = `group_paths(group_id, path) AS (
+		SELECT group_id, group_name FROM groups WHERE parent_group_id IS NULL
+		UNION ALL
+		SELECT g.group_id, gp.path || '/' || g.group_name
+		FROM groups g
+		JOIN group_paths gp ON g.parent_group_id = gp.group_id
+	)`
 
-		// Ensure the database is accessible
-		if err = DB.Ping(); err != nil {
-			err = fmt.Errorf("failed to ping database: %w", err)
+// InitDatabase opens the database and brings its schema up to date, creating the tables on the first run.
+func InitDatabase() error {
+	once.Do(func() {
+		if initErr = Open(); initErr != nil {
 			return
 		}
 
-		// Create necessary tables
-		_, err = DB.ExecContext(
-			context.Background(),
-			Tables,
-		)
+		applied, backup, err := Default.Migrate(context.Background())
 		if err != nil {
-			err = fmt.Errorf("failed to create tables: %w", err)
+			initErr = fmt.Errorf("failed to migrate database: %w", err)
 			return
 		}
+		if backup != "" {
+			fmt.Fprintf(Messages, "Upgraded the database to version %d, the previous version was saved to %s\n", applied[len(applied)-1].Version, backup)
+		}
 	})
 
-	return err
+	return initErr
 }
 
-// RegisterFileEntry adds a file entry to the files table in the database.
-func RegisterFileEntry(ctx context.Context, fileStructure *FilesLocal) (int64, error) {
-	result, err := DB.ExecContext(
-		ctx,
-		"INSERT INTO files (name, total_parts, size, hash, group_id) VALUES (?, ?, ?, ?, ?)",
-		fileStructure.Name, fileStructure.Total_parts, fileStructure.Size, fileStructure.Hash, fileStructure.GroupID,
-	)
-	if err != nil {
-		return 0, fmt.Errorf("failed to register file: %w", err)
-	}
-
-	fileID, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to retrieve last inserted ID: %w", err)
-	}
-
-	fmt.Printf("The file ID: %v\n", fileID)
-	return fileID, nil
-}
-
-// InsertParts Allows for registering the uploaded files in the database
-/* (data Parts) is the []string of data of registered files */
-func (parts *Parts) InsertParts(ctx context.Context, data Parts) error {
-	// Prepare the insert statement
-	stmt, err := DB.PrepareContext(ctx, "INSERT INTO parts (part_id, file_id) VALUES (?, ?)")
-	if err != nil {
-		return fmt.Errorf("failed to prepare statement: %w", err)
-	}
-	defer stmt.Close()
-
-	// Iterate over each part and insert into the database
-	for _, partID := range data.Parts {
-		_, err := stmt.ExecContext(ctx, partID, data.FileID)
+// Open connects to the database and sets Default without touching the schema, most commands want InitDatabase instead.
+func Open() error {
+	openOnce.Do(func() {
+		// Create the output directory
+		if openErr = os.MkdirAll(DataDir, 0755); openErr != nil {
+			openErr = fmt.Errorf("failed to create data directory: %w", openErr)
+			return
+		}
+		// Open the database connection, the pragmas are applied to every connection of the pool.
+		// Foreign keys are off in SQLite unless asked for, WAL lets readers and a writer work at the same time
+		// and the busy timeout makes concurrent writers wait for each other instead of failing.
+		conn, err := sql.Open("sqlite", "file:"+Path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
 		if err != nil {
-			log.Printf("failed to insert part: %s for file ID: %d, error: %v", partID, data.FileID, err)
-			return err // the function will stop
+			openErr = fmt.Errorf("failed to open database: %w", err)
+			return
 		}
-		fmt.Printf("Successfully inserted part: %s for file ID: %d\n", partID, data.FileID)
-	}
-
-	return nil
-}
-
-// gets all the fileparts for use by different function,
-// it returns them in orders since discord uses a timestamp as id it works fine
-func GetPartIDs(ctx context.Context, fileID int) ([]string, error) {
-	rows, err := DB.QueryContext(ctx, "SELECT part_id FROM parts WHERE file_id = ? ORDER BY part_id", fileID)
-	if err != nil {
-		return nil, fmt.Errorf("error querying parts: %w", err)
-	}
-	defer rows.Close()
 
-	var partIDs []string
-	for rows.Next() {
-		var partID string
-		if err := rows.Scan(&partID); err != nil {
-			return nil, fmt.Errorf("error scanning part_id: %w", err)
+		// Ensure the database is accessible
+		if err := conn.Ping(); err != nil {
+			openErr = fmt.Errorf("failed to ping database: %w", err)
+			return
 		}
-		partIDs = append(partIDs, partID)
-	}
+		Default = NewStore(conn)
+	})
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over rows: %w", err)
-	}
+	return openErr
+}
 
-	return partIDs, nil
+// Close closes the connection of Default, the next InitDatabase or Open connects again.
+// Used when data/db.sql is replaced while the program runs.
+func Close() error {
+	if Default == nil {
+		return nil
+	}
+	err := Default.conn.Close()
+	Default = nil
+	once, initErr = sync.Once{}, nil
+	openOnce, openErr = sync.Once{}, nil
+	return err
 }
 
 // FilesLocal represents a local file's metadata.
@@ -176,6 +234,18 @@ type FilesLocal struct {
 	Size        int64  // Size of the file
 	Hash        string // Hash for verifying file integrity, on complete download user may check for hash match
 	GroupID     int    // User may assign group to each file for future search commands, multiple files may belong to same group i.e. math books
+	UUID        string // Identifies the file in the headers of its part messages, see NewUUID
+
+	UploadedAt   time.Time   // When the upload started
+	ModTime      time.Time   // Modification time of the original file, may be restored on download
+	Mode         os.FileMode // Permission bits of the original file, may be restored on download
+	MimeType     string      // Sniffed from the content, falls back to the extension
+	OriginalPath string      // Absolute path of the file on the uploading machine
+	Host         string      // Host name of the uploading machine
+
+	Version   int  // Counts the uploads of a name to a group, starting at 1
+	LineageID int  // ID of the first version, the file's own ID when it is the first
+	Replaced  bool // A newer version was uploaded
 }
 
 // FilesDB represents a file entry in the database, including its ID.
@@ -184,8 +254,16 @@ type FilesDB struct {
 	FilesLocal
 }
 
+// Part is a single uploaded chunk of a file
+type Part struct {
+	MessageID string // ID of the Discord message holding the chunk, PRIMARY KEY
+	Index     int    // Position of the chunk in the file, starting at 0
+	Size      int64  // Size of the chunk in bytes
+	Hash      string // SHA-256 of the chunk, to check single parts without downloading the whole file
+}
+
 // Used to register the file parts to the DB
 type Parts struct {
-	FileID int      // File ID that was registered earlier
-	Parts  []string // The parts message IDs uploaded by the app.UploadFile() function
+	FileID int    // File ID that was registered earlier
+	Parts  []Part // The parts uploaded by the app.UploadFile() function
 }
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BackupRoot is a directory backed up with the backup command
+type BackupRoot struct {
+	ID      int
+	Path    string // Absolute path of the directory
+	GroupID int    // Group the files of the directory are uploaded to
+}
+
+// BackupEntry is what the last backup of a root saw of one of its files
+type BackupEntry struct {
+	Path    string // Relative to the root, separated by slashes
+	Size    int64
+	ModTime time.Time
+	Hash    string // SHA-256 of the content
+	FileID  int    // Uploaded version, 0 once it was deleted from the vault
+	Removed time.Time
+}
+
+// Snapshot is a run of the backup command with how many files it found in each state
+type Snapshot struct {
+	ID        int
+	Name      string // Empty for unnamed snapshots
+	RootID    int
+	RootPath  string // Set when read back from the database
+	Files     int    // Files recorded in the snapshot, set when read back from the database
+	Size      int64  // Total size of the recorded files, set when read back from the database
+	Created   time.Time
+	Added     int
+	Changed   int
+	Unchanged int
+	Removed   int
+	Failed    int
+}
+
+// BackupRootByPath returns the root of the directory, the error matches ErrNotFound if it was never backed up
+func (s *Store) BackupRootByPath(ctx context.Context, path string) (BackupRoot, error) {
+	root := BackupRoot{Path: path}
+	err := s.q.QueryRowContext(ctx, "SELECT root_id, group_id FROM backup_roots WHERE path = ?", path).Scan(&root.ID, &root.GroupID)
+	switch {
+	case err == sql.ErrNoRows:
+		return root, notFound("%s was never backed up", path)
+	case err != nil:
+		return root, fmt.Errorf("error fetching backup root: %w", err)
+	}
+	return root, nil
+}
+
+// CreateBackupRoot registers a directory whose files are uploaded to the group
+func (s *Store) CreateBackupRoot(ctx context.Context, path string, groupID int) (BackupRoot, error) {
+	result, err := s.q.ExecContext(ctx, "INSERT INTO backup_roots (path, group_id) VALUES (?, ?)", path, groupID)
+	if err != nil {
+		return BackupRoot{}, fmt.Errorf("error creating backup root: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return BackupRoot{}, fmt.Errorf("failed to retrieve last inserted ID: %w", err)
+	}
+	return BackupRoot{ID: int(id), Path: path, GroupID: groupID}, nil
+}
+
+// BackupEntries returns the files recorded for a root by their path, removed ones included
+func (s *Store) BackupEntries(ctx context.Context, rootID int) (map[string]BackupEntry, error) {
+	rows, err := s.q.QueryContext(ctx,
+		"SELECT path, size, modified_ns, hash, file_id, removed_at FROM backup_entries WHERE root_id = ?", rootID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying backup entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make(map[string]BackupEntry)
+	for rows.Next() {
+		var e BackupEntry
+		var modified int64
+		var fileID, removed sql.NullInt64
+		if err := rows.Scan(&e.Path, &e.Size, &modified, &e.Hash, &fileID, &removed); err != nil {
+			return nil, fmt.Errorf("error scanning backup entry: %w", err)
+		}
+		e.ModTime = time.Unix(0, modified)
+		e.FileID = int(fileID.Int64)
+		if removed.Valid {
+			e.Removed = time.Unix(removed.Int64, 0)
+		}
+		entries[e.Path] = e
+	}
+	return entries, rows.Err()
+}
+
+// SaveBackupEntry records a file of a root as present in the directory
+func (s *Store) SaveBackupEntry(ctx context.Context, rootID int, e BackupEntry) error {
+	var fileID interface{}
+	if e.FileID != 0 {
+		fileID = e.FileID
+	}
+	_, err := s.q.ExecContext(ctx, `INSERT INTO backup_entries (root_id, path, size, modified_ns, hash, file_id, removed_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULL)
+		ON CONFLICT (root_id, path) DO UPDATE SET
+			size = excluded.size, modified_ns = excluded.modified_ns, hash = excluded.hash,
+			file_id = excluded.file_id, removed_at = NULL`,
+		rootID, e.Path, e.Size, e.ModTime.UnixNano(), e.Hash, fileID)
+	if err != nil {
+		return fmt.Errorf("error saving backup entry %s: %w", e.Path, err)
+	}
+	return nil
+}
+
+// MarkRemoved records that the files are gone from the directory of the root
+func (s *Store) MarkRemoved(ctx context.Context, rootID int, paths []string) error {
+	return s.InTx(ctx, func(tx *Store) error {
+		now := time.Now().Unix()
+		for _, path := range paths {
+			_, err := tx.q.ExecContext(ctx, "UPDATE backup_entries SET removed_at = ? WHERE root_id = ? AND path = ?", now, rootID, path)
+			if err != nil {
+				return fmt.Errorf("error marking %s as removed: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// CreateSnapshot records a run of the backup command together with every file of the root that
+// isn't marked as removed and has an uploaded version. The ID and creation time are set on snapshot.
+func (s *Store) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	snapshot.Created = time.Now()
+	var name interface{}
+	if snapshot.Name != "" {
+		name = snapshot.Name
+	}
+	return s.InTx(ctx, func(tx *Store) error {
+		result, err := tx.q.ExecContext(ctx, `INSERT INTO snapshots (root_id, name, created_at, added, changed, unchanged, removed, failed)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			snapshot.RootID, name, snapshot.Created.Unix(),
+			snapshot.Added, snapshot.Changed, snapshot.Unchanged, snapshot.Removed, snapshot.Failed)
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				return ErrSnapshotExists
+			}
+			return fmt.Errorf("error creating snapshot: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve last inserted ID: %w", err)
+		}
+		snapshot.ID = int(id)
+
+		_, err = tx.q.ExecContext(ctx, `INSERT INTO snapshot_files (snapshot_id, path, file_id)
+			SELECT ?, path, file_id FROM backup_entries
+			WHERE root_id = ? AND removed_at IS NULL AND file_id IS NOT NULL`, id, snapshot.RootID)
+		if err != nil {
+			return fmt.Errorf("error recording the files of the snapshot: %w", err)
+		}
+		return nil
+	})
+}
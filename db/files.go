@@ -0,0 +1,540 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is a registered file as read back from the database, with the names of its group and its tags
+type File struct {
+	FilesDB
+	State     string    // One of FileStates
+	DeletedAt time.Time // When the file was moved to the trash, zero unless it is trashed
+	GroupName string    // Name of the group the file is in
+	GroupPath string    // Full path of the group, i.e. projects/alpha
+	Tags      []string  // Tag names in alphabetical order
+}
+
+// States of a file
+const (
+	StateActive   = "active"   // Uploaded and available
+	StateTrashed  = "trashed"  // Deleted by the user but still on Discord, can be restored
+	StateDeleting = "deleting" // A delete started, some of its parts may be gone already
+)
+
+// FileStates lists every state a file can be in
+var FileStates = []string{StateActive, StateTrashed, StateDeleting}
+
+// IDRange is an inclusive range of file IDs, a single ID has the same From and To
+type IDRange struct {
+	From int
+	To   int
+}
+
+// ValidFileName reports whether name can be used for a file: it isn't empty and is a local path, so
+// saving the file under its name below a directory can't write outside of it. Slashes are allowed,
+// files uploaded by the backup and watch commands are named by their path inside the directory.
+func ValidFileName(name string) bool {
+	return name != "" && filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// FileFilter picks the files ListFiles, CountFiles and MoveFiles work on, every filter that is set
+// has to match. The zero value of a filter means it isn't applied, so the zero FileFilter matches every file.
+type FileFilter struct {
+	IDs            []int     // Any of these file IDs
+	IDRanges       []IDRange // Any of these ranges of file IDs, a file in either IDs or IDRanges matches
+	Search         string    // Pattern matched against file names using SQL LIKE
+	GroupID        int       // Group ID
+	Recursive      bool      // Also match the child groups of GroupID
+	MinSize        *int64    // Smallest size in bytes
+	MaxSize        *int64    // Biggest size in bytes
+	UploadedAfter  time.Time // Uploaded at or after
+	UploadedBefore time.Time // Uploaded before
+	Exts           []string  // File extensions without the dot, any of them matches
+	HashPrefix     string    // Prefix of the SHA-256 hash
+	Regex          string    // Regular expression matched against file names
+	Tags           []string  // Tag names, all of them must be on the file
+	States         []string  // Any of these states, only StateActive when empty
+	DeletedBefore  time.Time // Moved to the trash before
+	LineageID      int       // Versions of this file, see FilesLocal.LineageID
+	AllVersions    bool      // Also match versions that were replaced by a newer upload
+
+	Sort   string // One of the keys of SortColumns, by ID if empty
+	Desc   bool   // Sort in descending order
+	Limit  int    // Maximum number of files returned, 0 means no limit
+	Offset int    // Number of matching files skipped
+}
+
+// SortColumns maps the sort keys of FileFilter to the SQL used for ordering
+var SortColumns = map[string]string{
+	"id":       "f.id",
+	"name":     "f.name COLLATE NOCASE",
+	"size":     "f.size",
+	"uploaded": "f.uploaded_at",
+	"version":  "f.version",
+}
+
+// where builds the WHERE conditions of the filter, they are appended to a query ending in `WHERE 1=1`
+func (f FileFilter) where() (string, []interface{}) {
+	var conditions string
+	// Parameters slice for query arguments
+	var params []interface{}
+
+	states := f.States
+	if len(states) == 0 {
+		states = []string{StateActive}
+	}
+	conditions += " AND f.state IN (?" + strings.Repeat(", ?", len(states)-1) + ")"
+	for _, state := range states {
+		params = append(params, state)
+	}
+
+	if !f.AllVersions {
+		conditions += " AND f.current = 1"
+	}
+	if f.LineageID != 0 {
+		conditions += " AND f.lineage_id = ?"
+		params = append(params, f.LineageID)
+	}
+
+	// Ranges are compared with BETWEEN rather than expanded, 1-100000 would go over the limit of bound parameters
+	var ids []string
+	if len(f.IDs) > 0 {
+		ids = append(ids, "f.id IN (?"+strings.Repeat(", ?", len(f.IDs)-1)+")")
+		for _, id := range f.IDs {
+			params = append(params, id)
+		}
+	}
+	for _, r := range f.IDRanges {
+		ids = append(ids, "f.id BETWEEN ? AND ?")
+		params = append(params, r.From, r.To)
+	}
+	if len(ids) > 0 {
+		conditions += " AND (" + strings.Join(ids, " OR ") + ")"
+	}
+	if f.Search != "" {
+		conditions += " AND f.name LIKE ?"
+		params = append(params, "%"+f.Search+"%")
+	}
+	if f.GroupID != 0 && !f.Recursive {
+		conditions += " AND f.group_id = ?"
+		params = append(params, f.GroupID)
+	}
+	if f.GroupID != 0 && f.Recursive {
+		conditions += ` AND f.group_id IN (
+			WITH RECURSIVE subgroups(group_id) AS (
+				SELECT ?
+				UNION ALL
+				SELECT g.group_id FROM groups g JOIN subgroups s ON g.parent_group_id = s.group_id
+			)
+			SELECT group_id FROM subgroups
+		)`
+		params = append(params, f.GroupID)
+	}
+	if f.MinSize != nil {
+		conditions += " AND f.size >= ?"
+		params = append(params, *f.MinSize)
+	}
+	if f.MaxSize != nil {
+		conditions += " AND f.size <= ?"
+		params = append(params, *f.MaxSize)
+	}
+	if !f.UploadedAfter.IsZero() {
+		conditions += " AND f.uploaded_at >= ?"
+		params = append(params, f.UploadedAfter.Unix())
+	}
+	if !f.UploadedBefore.IsZero() {
+		conditions += " AND f.uploaded_at < ?"
+		params = append(params, f.UploadedBefore.Unix())
+	}
+	if len(f.Exts) > 0 {
+		var exts []string
+		for _, ext := range f.Exts {
+			exts = append(exts, `f.name LIKE ? ESCAPE '\'`)
+			params = append(params, "%."+escapeLike(ext))
+		}
+		conditions += " AND (" + strings.Join(exts, " OR ") + ")"
+	}
+	if !f.DeletedBefore.IsZero() {
+		conditions += " AND f.deleted_at < ?"
+		params = append(params, f.DeletedBefore.Unix())
+	}
+	if f.HashPrefix != "" {
+		conditions += ` AND f.hash LIKE ? ESCAPE '\'`
+		params = append(params, escapeLike(f.HashPrefix)+"%")
+	}
+	if f.Regex != "" {
+		conditions += " AND f.name REGEXP ?"
+		params = append(params, f.Regex)
+	}
+	for _, tag := range f.Tags {
+		conditions += ` AND f.id IN (
+			SELECT ft.file_id FROM file_tags ft JOIN tags t ON t.tag_id = ft.tag_id WHERE t.tag_name = ?
+		)`
+		params = append(params, tag)
+	}
+
+	return conditions, params
+}
+
+// likeEscaper escapes the wildcards of LIKE, for patterns used with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match itself literally in a LIKE pattern
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// fileSelectSQL selects the columns read by scanFile, queries append their own conditions to it
+const fileSelectSQL = `WITH RECURSIVE ` + groupPathsSQL + `
+		SELECT f.id, f.name, f.size, f.total_parts, f.hash, f.group_id, f.uuid, f.state, g.group_name, gp.path,
+			(` + fileTagsSQL + ` WHERE ft.file_id = f.id),
+			f.uploaded_at, f.modified_at, f.mode, f.mime_type, f.original_path, f.uploader_host, f.deleted_at,
+			f.version, f.lineage_id, f.current
+		FROM files f
+		LEFT JOIN groups g ON f.group_id = g.group_id
+		LEFT JOIN group_paths gp ON gp.group_id = f.group_id
+		WHERE 1=1
+	`
+
+// scanFile reads a row selected with fileSelectSQL
+func scanFile(rows *sql.Rows) (File, error) {
+	var file File
+	var uuid, groupName, groupPath, tags, mimeType, originalPath, host sql.NullString
+	var uploadedAt, modifiedAt, mode, deletedAt, lineageID sql.NullInt64
+	var current bool
+	err := rows.Scan(
+		&file.ID, &file.Name, &file.Size, &file.Total_parts, &file.Hash, &file.GroupID, &uuid, &file.State, &groupName, &groupPath, &tags,
+		&uploadedAt, &modifiedAt, &mode, &mimeType, &originalPath, &host, &deletedAt,
+		&file.Version, &lineageID, &current,
+	)
+	if err != nil {
+		return file, err
+	}
+	file.UUID = uuid.String
+	file.GroupName = groupName.String
+	file.GroupPath = groupPath.String
+	file.Tags = strings.Fields(tags.String)
+
+	if uploadedAt.Valid {
+		file.UploadedAt = time.Unix(uploadedAt.Int64, 0)
+	}
+	if modifiedAt.Valid {
+		file.ModTime = time.Unix(modifiedAt.Int64, 0)
+	}
+	if deletedAt.Valid {
+		file.DeletedAt = time.Unix(deletedAt.Int64, 0)
+	}
+	file.Mode = os.FileMode(mode.Int64)
+	file.LineageID = int(lineageID.Int64)
+	file.Replaced = !current
+	file.MimeType = mimeType.String
+	file.OriginalPath = originalPath.String
+	file.Host = host.String
+	return file, nil
+}
+
+// ListFiles returns the files matching the filter in the order and page it asks for.
+// f.id breaks ties in the ordering so pages never overlap.
+func (s *Store) ListFiles(ctx context.Context, filter FileFilter) ([]File, error) {
+	conditions, params := filter.where()
+
+	sortKey := filter.Sort
+	if sortKey == "" {
+		sortKey = "id"
+	}
+	orderBy, ok := SortColumns[sortKey]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort key '%s', use name, size, id or uploaded", filter.Sort)
+	}
+	direction := "ASC"
+	if filter.Desc {
+		direction = "DESC"
+	}
+
+	query := fileSelectSQL + conditions + " ORDER BY " + orderBy + " " + direction + ", f.id " + direction
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		params = append(params, filter.Limit, filter.Offset)
+	} else if filter.Offset > 0 {
+		query += " LIMIT -1 OFFSET ?"
+		params = append(params, filter.Offset)
+	}
+
+	rows, err := s.q.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning file: %w", err)
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return files, nil
+}
+
+// CountFiles returns how many files match the filter, ignoring its ordering and paging
+func (s *Store) CountFiles(ctx context.Context, filter FileFilter) (int, error) {
+	conditions, params := filter.where()
+
+	var total int
+	err := s.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM files f WHERE 1=1"+conditions, params...).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("error counting files: %w", err)
+	}
+	return total, nil
+}
+
+// GetFile returns a single file in any state and version, the error matches ErrNotFound if there is no file with the ID
+func (s *Store) GetFile(ctx context.Context, id int) (File, error) {
+	files, err := s.ListFiles(ctx, FileFilter{IDs: []int{id}, States: FileStates, AllVersions: true})
+	if err != nil {
+		return File{}, err
+	}
+	if len(files) == 0 {
+		return File{}, notFound("no file found with ID: %d", id)
+	}
+	return files[0], nil
+}
+
+// Versions returns every version of the file in any state, the oldest first
+func (s *Store) Versions(ctx context.Context, id int) ([]File, error) {
+	file, err := s.GetFile(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListFiles(ctx, FileFilter{LineageID: file.LineageID, States: FileStates, AllVersions: true, Sort: "version"})
+}
+
+// SetFileState moves a file into another state
+func (s *Store) SetFileState(ctx context.Context, id int, state string) error {
+	if _, err := s.q.ExecContext(ctx, "UPDATE files SET state = ? WHERE id = ?", state, id); err != nil {
+		return fmt.Errorf("failed to change the state of file %d: %w", id, err)
+	}
+	return nil
+}
+
+// RenameFile changes the name of a file together with all of its versions
+func (s *Store) RenameFile(ctx context.Context, id int, name string) error {
+	result, err := s.q.ExecContext(ctx, "UPDATE files SET name = ? WHERE lineage_id = (SELECT lineage_id FROM files WHERE id = ?)", name, id)
+	if err != nil {
+		return fmt.Errorf("failed to rename file %d: %w", id, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return notFound("no file found with ID: %d", id)
+	}
+	return nil
+}
+
+// MoveFiles puts every file matching the filter into the group together with all of their versions
+// and returns how many files matched. The ordering and paging of the filter are ignored.
+func (s *Store) MoveFiles(ctx context.Context, groupID int, filter FileFilter) (int64, error) {
+	conditions, params := filter.where()
+
+	var moved int64
+	err := s.InTx(ctx, func(tx *Store) error {
+		if err := tx.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM files f WHERE 1=1"+conditions, params...).Scan(&moved); err != nil {
+			return fmt.Errorf("error counting files: %w", err)
+		}
+		_, err := tx.q.ExecContext(ctx,
+			"UPDATE files SET group_id = ? WHERE lineage_id IN (SELECT f.lineage_id FROM files f WHERE 1=1"+conditions+")",
+			append([]interface{}{groupID}, params...)...,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to move files: %w", err)
+		}
+		return nil
+	})
+	return moved, err
+}
+
+// RegisterFile adds an uploaded file and its parts to the database in one transaction,
+// so a file is never registered without its parts. parts.FileID is set to the new file ID.
+func (s *Store) RegisterFile(ctx context.Context, fileStructure *FilesLocal, parts *Parts) (int64, error) {
+	var fileID int64
+	err := s.InTx(ctx, func(tx *Store) error {
+		previous, err := tx.latestVersion(ctx, fileStructure.Name, fileStructure.GroupID)
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			fileStructure.Version = previous.Version + 1
+			fileStructure.LineageID = previous.LineageID
+		}
+
+		fileID, err = tx.insertFile(ctx, fileStructure, parts)
+		if err != nil || previous == nil {
+			return err
+		}
+		return tx.replaceVersion(ctx, previous.ID, int(fileID))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Fprintf(Messages, "The file ID: %v\n", fileID)
+	if fileStructure.Version > 1 {
+		fmt.Fprintf(Messages, "Stored as version %d of %s\n", fileStructure.Version, fileStructure.Name)
+	}
+	fmt.Fprintf(Messages, "Registered %d part(s) for file ID: %d\n", len(parts.Parts), fileID)
+	return fileID, nil
+}
+
+// RegisterRecoveredFile adds a file recovered from the channel and its parts in one transaction. It always
+// starts its own lineage, a name that is taken in the group is numbered like "name (1).ext" so the file
+// doesn't become a version of an unrelated one. fileStructure.Name is set to the name it got.
+func (s *Store) RegisterRecoveredFile(ctx context.Context, fileStructure *FilesLocal, parts *Parts) (int64, error) {
+	var fileID int64
+	err := s.InTx(ctx, func(tx *Store) error {
+		name, err := tx.freeName(ctx, fileStructure.Name, fileStructure.GroupID)
+		if err != nil {
+			return err
+		}
+		fileStructure.Name = name
+		fileID, err = tx.insertFile(ctx, fileStructure, parts)
+		return err
+	})
+	return fileID, err
+}
+
+// insertFile adds a file and its parts, use it inside a transaction
+func (s *Store) insertFile(ctx context.Context, fileStructure *FilesLocal, parts *Parts) (int64, error) {
+	// Metadata that isn't known, i.e. for files recovered by rebuild, is stored as NULL
+	var modifiedAt, mode interface{}
+	if !fileStructure.ModTime.IsZero() {
+		modifiedAt = fileStructure.ModTime.Unix()
+		mode = uint32(fileStructure.Mode)
+	}
+	if fileStructure.UUID == "" {
+		fileStructure.UUID = NewUUID()
+	}
+	if fileStructure.Version == 0 {
+		fileStructure.Version = 1
+	}
+	// The first version of a file starts its own lineage, set once the ID is known
+	var lineageID interface{}
+	if fileStructure.LineageID != 0 {
+		lineageID = fileStructure.LineageID
+	}
+
+	result, err := s.q.ExecContext(
+		ctx,
+		`INSERT INTO files (name, total_parts, size, hash, group_id, uuid, uploaded_at, modified_at, mode, mime_type, original_path, uploader_host,
+			version, lineage_id, current)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		fileStructure.Name, fileStructure.Total_parts, fileStructure.Size, fileStructure.Hash, fileStructure.GroupID, fileStructure.UUID,
+		fileStructure.UploadedAt.Unix(), modifiedAt, mode,
+		fileStructure.MimeType, fileStructure.OriginalPath, fileStructure.Host,
+		fileStructure.Version, lineageID, !fileStructure.Replaced,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register file: %w", err)
+	}
+
+	fileID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve last inserted ID: %w", err)
+	}
+	parts.FileID = int(fileID)
+	if fileStructure.LineageID == 0 {
+		fileStructure.LineageID = int(fileID)
+		if _, err := s.q.ExecContext(ctx, "UPDATE files SET lineage_id = id WHERE id = ?", fileID); err != nil {
+			return 0, fmt.Errorf("failed to set the lineage of the file: %w", err)
+		}
+	}
+
+	return fileID, s.insertParts(ctx, *parts)
+}
+
+// latestVersion returns the current version of the active file with the name in the group, nil if there is none
+func (s *Store) latestVersion(ctx context.Context, name string, groupID int) (*FilesDB, error) {
+	var file FilesDB
+	err := s.q.QueryRowContext(ctx, `SELECT id, version, lineage_id FROM files
+		WHERE name = ? AND group_id = ? AND state = ? AND current = 1
+		ORDER BY version DESC LIMIT 1`, name, groupID, StateActive).Scan(&file.ID, &file.Version, &file.LineageID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error looking up previous versions: %w", err)
+	}
+	return &file, nil
+}
+
+// replaceVersion makes the file the current version of its lineage in place of the previous one,
+// the tags and the note of the previous version are carried over
+func (s *Store) replaceVersion(ctx context.Context, previousID, fileID int) error {
+	_, err := s.q.ExecContext(ctx,
+		"UPDATE files SET current = 0 WHERE lineage_id = (SELECT lineage_id FROM files WHERE id = ?) AND id != ?", fileID, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to replace the previous version: %w", err)
+	}
+	if _, err := s.q.ExecContext(ctx, "INSERT INTO file_tags (file_id, tag_id) SELECT ?, tag_id FROM file_tags WHERE file_id = ?", fileID, previousID); err != nil {
+		return fmt.Errorf("error copying tags: %w", err)
+	}
+	if _, err := s.q.ExecContext(ctx, "INSERT INTO file_notes (file_id, note) SELECT ?, note FROM file_notes WHERE file_id = ?", fileID, previousID); err != nil {
+		return fmt.Errorf("error copying note: %w", err)
+	}
+	return nil
+}
+
+// FileIDsByUUID maps the UUID of every file to its ID
+func (s *Store) FileIDsByUUID(ctx context.Context) (map[string]int, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT uuid, id FROM files WHERE uuid IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("error querying files: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int)
+	for rows.Next() {
+		var uuid string
+		var id int
+		if err := rows.Scan(&uuid, &id); err != nil {
+			return nil, fmt.Errorf("error scanning file: %w", err)
+		}
+		ids[uuid] = id
+	}
+	return ids, rows.Err()
+}
+
+// NewUUID returns a random (version 4) UUID
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// FileModeAndTime returns the permission bits and modification time recorded for a file at upload,
+// ok is false for files uploaded before they were recorded
+func (s *Store) FileModeAndTime(ctx context.Context, fileID int) (mode os.FileMode, modTime time.Time, ok bool, err error) {
+	var m, t sql.NullInt64
+	err = s.q.QueryRowContext(ctx, "SELECT mode, modified_at FROM files WHERE id = ?", fileID).Scan(&m, &t)
+	if err != nil {
+		return 0, time.Time{}, false, fmt.Errorf("error querying file metadata: %w", err)
+	}
+	if !m.Valid || !t.Valid {
+		return 0, time.Time{}, false, nil
+	}
+	return os.FileMode(m.Int64).Perm(), time.Unix(t.Int64, 0), true, nil
+}
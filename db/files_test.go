@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListFilesFilters(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	projects := createTestGroup(t, s, "projects", 0)
+	alpha := createTestGroup(t, s, "alpha", projects)
+
+	report := registerTestFile(t, s, "report.pdf", 1, 100)
+	photo := registerTestFile(t, s, "photo_1.jpg", projects, 2000)
+	notes := registerTestFile(t, s, "notes.txt", alpha, 30)
+	archive := registerTestFile(t, s, "archive.tar.gz", alpha, 50000)
+	if err := s.AddTags(ctx, photo, []string{"holiday", "2024"}); err != nil {
+		t.Fatalf("AddTags: %v", err)
+	}
+	if err := s.AddTags(ctx, notes, []string{"holiday"}); err != nil {
+		t.Fatalf("AddTags: %v", err)
+	}
+	trashed := registerTestFile(t, s, "old.pdf", 1, 10)
+	if err := s.SetFileState(ctx, trashed, StateTrashed); err != nil {
+		t.Fatalf("SetFileState: %v", err)
+	}
+	photoFile, _ := s.GetFile(ctx, photo)
+
+	minSize, maxSize := int64(50), int64(5000)
+	tests := []struct {
+		name   string
+		filter FileFilter
+		want   []int
+	}{
+		{"everything active", FileFilter{}, []int{report, photo, notes, archive}},
+		{"ids", FileFilter{IDs: []int{notes, report}}, []int{report, notes}},
+		{"id ranges", FileFilter{IDRanges: []IDRange{{From: photo, To: notes}, {From: archive, To: archive + 100}}}, []int{photo, notes, archive}},
+		{"ids or ranges", FileFilter{IDs: []int{report}, IDRanges: []IDRange{{From: archive, To: archive}}}, []int{report, archive}},
+		{"search", FileFilter{Search: "o"}, []int{report, photo, notes}},
+		{"group", FileFilter{GroupID: projects}, []int{photo}},
+		{"group recursive", FileFilter{GroupID: projects, Recursive: true}, []int{photo, notes, archive}},
+		{"size", FileFilter{MinSize: &minSize, MaxSize: &maxSize}, []int{report, photo}},
+		{"extensions", FileFilter{Exts: []string{"pdf", "gz"}}, []int{report, archive}},
+		{"extension wildcard is literal", FileFilter{Exts: []string{"p_f"}}, nil},
+		{"hash prefix", FileFilter{HashPrefix: photoFile.Hash[:12]}, []int{photo}},
+		{"hash wildcard is literal", FileFilter{HashPrefix: "%"}, nil},
+		{"regex", FileFilter{Regex: `^[a-z]+_\d`}, []int{photo}},
+		{"tags", FileFilter{Tags: []string{"holiday"}}, []int{photo, notes}},
+		{"all tags", FileFilter{Tags: []string{"holiday", "2024"}}, []int{photo}},
+		{"uploaded", FileFilter{UploadedAfter: photoFile.UploadedAt, UploadedBefore: photoFile.UploadedAt.Add(2 * time.Second)}, []int{photo, notes}},
+		{"trashed", FileFilter{States: []string{StateTrashed}}, []int{trashed}},
+		{"sort and page", FileFilter{Sort: "size", Desc: true, Limit: 2, Offset: 1}, []int{photo, report}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := s.ListFiles(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListFiles: %v", err)
+			}
+			if got := fileIDs(files); !reflect.DeepEqual(got, tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+				t.Errorf("got files %v, want %v", got, tt.want)
+			}
+			count, err := s.CountFiles(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("CountFiles: %v", err)
+			}
+			if tt.filter.Limit == 0 && count != len(tt.want) {
+				t.Errorf("CountFiles returned %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+
+	if _, err := s.ListFiles(ctx, FileFilter{Sort: "colour"}); err == nil {
+		t.Error("ListFiles accepted an unknown sort key")
+	}
+}
+
+func TestRegisterFileVersions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	photos := createTestGroup(t, s, "photos", 0)
+
+	first := registerTestFile(t, s, "report.pdf", 1, 100)
+	if err := s.AddTags(ctx, first, []string{"tax"}); err != nil {
+		t.Fatalf("AddTags: %v", err)
+	}
+	if err := s.SetNote(ctx, first, "2024 return"); err != nil {
+		t.Fatalf("SetNote: %v", err)
+	}
+	second := registerTestFile(t, s, "report.pdf", 1, 120)
+	other := registerTestFile(t, s, "report.pdf", photos, 130) // Another group starts its own lineage
+
+	versions, err := s.Versions(ctx, first)
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	if got := fileIDs(versions); !reflect.DeepEqual(got, []int{first, second}) {
+		t.Fatalf("versions are %v, want %v", got, []int{first, second})
+	}
+	old, current := versions[0], versions[1]
+	if old.Version != 1 || !old.Replaced || current.Version != 2 || current.Replaced {
+		t.Errorf("versions are %d (replaced %v) and %d (replaced %v)", old.Version, old.Replaced, current.Version, current.Replaced)
+	}
+	if old.LineageID != first || current.LineageID != first {
+		t.Errorf("lineages are %d and %d, want %d", old.LineageID, current.LineageID, first)
+	}
+	if !reflect.DeepEqual(current.Tags, []string{"tax"}) {
+		t.Errorf("new version has tags %v, want the tags of the previous one", current.Tags)
+	}
+	if note, err := s.Note(ctx, second); err != nil || note != "2024 return" {
+		t.Errorf("new version has note %q, err %v, want the note of the previous one", note, err)
+	}
+
+	// Only current versions are listed unless asked for
+	files, err := s.ListFiles(ctx, FileFilter{Search: "report"})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if got := fileIDs(files); !reflect.DeepEqual(got, []int{second, other}) {
+		t.Errorf("current files are %v, want %v", got, []int{second, other})
+	}
+	files, err = s.ListFiles(ctx, FileFilter{Search: "report", AllVersions: true})
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if got := fileIDs(files); !reflect.DeepEqual(got, []int{first, second, other}) {
+		t.Errorf("all versions are %v, want %v", got, []int{first, second, other})
+	}
+}
+
+func TestRenameFileRenamesLineage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	first := registerTestFile(t, s, "draft.txt", 1, 10)
+	second := registerTestFile(t, s, "draft.txt", 1, 20)
+	unrelated := registerTestFile(t, s, "other.txt", 1, 30)
+
+	// Renaming an old version renames the whole file
+	if err := s.RenameFile(ctx, first, "final.txt"); err != nil {
+		t.Fatalf("RenameFile: %v", err)
+	}
+	for _, id := range []int{first, second} {
+		if f, _ := s.GetFile(ctx, id); f.Name != "final.txt" {
+			t.Errorf("file %d is named %q, want final.txt", id, f.Name)
+		}
+	}
+	if f, _ := s.GetFile(ctx, unrelated); f.Name != "other.txt" {
+		t.Errorf("unrelated file was renamed to %q", f.Name)
+	}
+
+	// The next upload under the new name continues the lineage
+	third := registerTestFile(t, s, "final.txt", 1, 40)
+	if f, _ := s.GetFile(ctx, third); f.Version != 3 || f.LineageID != first {
+		t.Errorf("upload after the rename is version %d of lineage %d, want 3 of %d", f.Version, f.LineageID, first)
+	}
+
+	if err := s.RenameFile(ctx, 9999, "missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("renaming a missing file returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestMoveFilesMovesLineage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	archive := createTestGroup(t, s, "archive", 0)
+	first := registerTestFile(t, s, "scan.png", 1, 10)
+	second := registerTestFile(t, s, "scan.png", 1, 20)
+	other := registerTestFile(t, s, "keep.png", 1, 30)
+
+	// The filter matches the current version only, the old one moves along
+	moved, err := s.MoveFiles(ctx, archive, FileFilter{Search: "scan"})
+	if err != nil {
+		t.Fatalf("MoveFiles: %v", err)
+	}
+	if moved != 1 {
+		t.Errorf("MoveFiles reported %d files, want 1", moved)
+	}
+	for _, id := range []int{first, second} {
+		if f, _ := s.GetFile(ctx, id); f.GroupID != archive {
+			t.Errorf("file %d is in group %d, want %d", id, f.GroupID, archive)
+		}
+	}
+	if f, _ := s.GetFile(ctx, other); f.GroupID != 1 {
+		t.Errorf("unmatched file moved to group %d", f.GroupID)
+	}
+
+	// A new upload to the old group starts a new file instead of continuing the moved one
+	fresh := registerTestFile(t, s, "scan.png", 1, 50)
+	if f, _ := s.GetFile(ctx, fresh); f.Version != 1 || f.LineageID != fresh {
+		t.Errorf("upload to the old group is version %d of lineage %d, want a new file", f.Version, f.LineageID)
+	}
+}
+
+func TestRegisterRecoveredFileStartsLineage(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	existing := registerTestFile(t, s, "report.pdf", 1, 100)
+
+	file := &FilesLocal{Name: "report.pdf", Total_parts: 1, Size: 50, Hash: "recovered", GroupID: 1}
+	parts := &Parts{Parts: []Part{{MessageID: "900", Size: 50}}}
+	id, err := s.RegisterRecoveredFile(ctx, file, parts)
+	if err != nil {
+		t.Fatalf("RegisterRecoveredFile: %v", err)
+	}
+	if file.Name != "report (1).pdf" {
+		t.Errorf("recovered file is named %q, want report (1).pdf", file.Name)
+	}
+
+	recovered, err := s.GetFile(ctx, int(id))
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if recovered.Version != 1 || recovered.LineageID != int(id) || recovered.Replaced {
+		t.Errorf("recovered file is version %d of lineage %d (replaced %v), want its own lineage", recovered.Version, recovered.LineageID, recovered.Replaced)
+	}
+	if f, _ := s.GetFile(ctx, existing); f.Replaced {
+		t.Error("the existing file was replaced by the recovered one")
+	}
+}
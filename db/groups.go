@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultGroupID is the 'uncategorized' group, files go there when no group is given
+const DefaultGroupID = 1
+
+// ErrGroupExists is returned by CreateGroup when the name is taken
+var ErrGroupExists = errors.New("a group with this name already exists")
+
+// Group is a group of files, groups may be nested inside a parent group
+type Group struct {
+	ID       int
+	Name     string
+	ParentID int    // 0 for root groups
+	Parent   string // Name of the parent group
+	Path     string // Full path of the group, i.e. projects/alpha
+}
+
+// GroupNode is a group inside a subtree returned by GroupTree
+type GroupNode struct {
+	ID    int
+	Name  string
+	Depth int // 0 for the root of the subtree
+}
+
+// ListGroups returns every group ordered by ID
+func (s *Store) ListGroups(ctx context.Context) ([]Group, error) {
+	// Fetch group details along with their parent group names using a LEFT JOIN
+	rows, err := s.q.QueryContext(ctx, `WITH RECURSIVE `+groupPathsSQL+`
+		SELECT g.group_id, g.group_name, g.parent_group_id, pg.group_name AS parent_group_name, gp.path
+		FROM groups g
+		LEFT JOIN groups pg ON g.parent_group_id = pg.group_id
+		LEFT JOIN group_paths gp ON gp.group_id = g.group_id
+		ORDER BY g.group_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		var g Group
+		var parentID sql.NullInt64
+		var parentGroupName, groupPath sql.NullString
+		if err := rows.Scan(&g.ID, &g.Name, &parentID, &parentGroupName, &groupPath); err != nil {
+			return nil, fmt.Errorf("error scanning group: %w", err)
+		}
+		g.ParentID = int(parentID.Int64)
+		g.Parent = parentGroupName.String
+		g.Path = groupPath.String
+		groups = append(groups, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return groups, nil
+}
+
+// GroupByName returns the ID of the group with the name, the error matches ErrNotFound if there is none
+func (s *Store) GroupByName(ctx context.Context, name string) (int, error) {
+	var groupID int
+	err := s.q.QueryRowContext(ctx, "SELECT group_id FROM groups WHERE group_name = ?", name).Scan(&groupID)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, notFound("no group found with name: %s", name)
+	case err != nil:
+		return 0, fmt.Errorf("error fetching group '%s': %w", name, err)
+	}
+	return groupID, nil
+}
+
+// GroupExists reports whether there is a group with the ID
+func (s *Store) GroupExists(ctx context.Context, id int) (bool, error) {
+	var count int
+	if err := s.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM groups WHERE group_id = ?", id).Scan(&count); err != nil {
+		return false, fmt.Errorf("error fetching group %d: %w", id, err)
+	}
+	return count > 0, nil
+}
+
+// GroupName returns the name of the group with the ID
+func (s *Store) GroupName(ctx context.Context, id int) (string, error) {
+	var name string
+	err := s.q.QueryRowContext(ctx, "SELECT group_name FROM groups WHERE group_id = ?", id).Scan(&name)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", notFound("no group found with ID: %d", id)
+	case err != nil:
+		return "", fmt.Errorf("error fetching group %d: %w", id, err)
+	}
+	return name, nil
+}
+
+// ResolveGroup accepts either a group name or a numeric group ID and returns the group ID.
+// Names are checked first so a group literally named "2024" still resolves by name.
+func (s *Store) ResolveGroup(ctx context.Context, ref string) (int, error) {
+	groupID, err := s.GroupByName(ctx, ref)
+	if !errors.Is(err, ErrNotFound) {
+		return groupID, err
+	}
+
+	id, convErr := strconv.Atoi(ref)
+	if convErr != nil {
+		return 0, err
+	}
+	exists, err := s.GroupExists(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, notFound("no group found with ID: %d", id)
+	}
+	return id, nil
+}
+
+// CreateGroup adds a group inside the parent group, or at the root if parentID is 0
+func (s *Store) CreateGroup(ctx context.Context, name string, parentID int) (int64, error) {
+	var parent interface{}
+	if parentID != 0 {
+		parent = parentID
+	}
+
+	result, err := s.q.ExecContext(ctx, "INSERT INTO groups (group_name, parent_group_id) VALUES (?, ?)", name, parent)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrGroupExists
+		}
+		return 0, fmt.Errorf("error creating group: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// GroupTree returns the group and all of its descendants in tree order,
+// so a parent always comes before its children.
+func (s *Store) GroupTree(ctx context.Context, rootID int) ([]GroupNode, error) {
+	rows, err := s.q.QueryContext(ctx, `
+		WITH RECURSIVE tree(group_id, group_name, depth, path) AS (
+			SELECT group_id, group_name, 0, printf('%010d', group_id) FROM groups WHERE group_id = ?
+			UNION ALL
+			SELECT g.group_id, g.group_name, t.depth + 1, t.path || '/' || printf('%010d', g.group_id)
+			FROM groups g
+			JOIN tree t ON g.parent_group_id = t.group_id
+		)
+		SELECT group_id, group_name, depth FROM tree ORDER BY path
+	`, rootID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying child groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []GroupNode
+	for rows.Next() {
+		var g GroupNode
+		if err := rows.Scan(&g.ID, &g.Name, &g.Depth); err != nil {
+			return nil, fmt.Errorf("error scanning group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// DeleteGroups deletes the groups of a subtree returned by GroupTree in one transaction.
+// The files still in them are moved to reassignTo first, pass 0 when they were deleted already.
+func (s *Store) DeleteGroups(ctx context.Context, groups []GroupNode, reassignTo int) error {
+	return s.InTx(ctx, func(tx *Store) error {
+		if reassignTo != 0 {
+			for _, g := range groups {
+				if _, err := tx.q.ExecContext(ctx, "UPDATE files SET group_id = ? WHERE group_id = ?", reassignTo, g.ID); err != nil {
+					return fmt.Errorf("error reassigning files of group %d: %w", g.ID, err)
+				}
+			}
+		}
+
+		// Walk the tree backwards so children are deleted before their parents
+		for i := len(groups) - 1; i >= 0; i-- {
+			if _, err := tx.q.ExecContext(ctx, "DELETE FROM groups WHERE group_id = ?", groups[i].ID); err != nil {
+				return fmt.Errorf("error deleting group %d: %w", groups[i].ID, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,436 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestVersion is the version of the manifest format written by Export
+const ManifestVersion = 1
+
+// Manifest is the catalog of a vault: its groups and files with everything needed to download them
+// again from the channel. Manifests are shared as JSON, IDs inside a manifest only refer to other
+// entries of the same manifest.
+type Manifest struct {
+	Version   int             `json:"version"`
+	Exported  time.Time       `json:"exported"`
+	ChannelID string          `json:"channel_id,omitempty"` // Channel the parts were uploaded to
+	Groups    []ManifestGroup `json:"groups"`
+	Files     []ManifestFile  `json:"files"`
+}
+
+// ManifestGroup is a group in a manifest
+type ManifestGroup struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID int    `json:"parent_id,omitempty"` // 0 for root groups
+}
+
+// ManifestFile is a file in a manifest
+type ManifestFile struct {
+	ID           int            `json:"id"`
+	UUID         string         `json:"uuid"`
+	Name         string         `json:"name"`
+	Size         int64          `json:"size"`
+	Hash         string         `json:"hash"`
+	GroupID      int            `json:"group_id"`
+	UploadedAt   time.Time      `json:"uploaded_at"`
+	ModifiedAt   *time.Time     `json:"modified_at,omitempty"`
+	Mode         uint32         `json:"mode,omitempty"`
+	MimeType     string         `json:"mime_type,omitempty"`
+	OriginalPath string         `json:"original_path,omitempty"`
+	Host         string         `json:"host,omitempty"`
+	Note         string         `json:"note,omitempty"`
+	Tags         []string       `json:"tags,omitempty"`
+	Parts        []ManifestPart `json:"parts"`
+}
+
+// ManifestPart is a part of a file in a manifest
+type ManifestPart struct {
+	MessageID string `json:"message_id"`
+	Index     int    `json:"index"`
+	Size      int64  `json:"size,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+}
+
+// Export returns the manifest of every group and of the current version of every file in the database
+func (s *Store) Export(ctx context.Context) (Manifest, error) {
+	manifest := Manifest{Version: ManifestVersion, Exported: time.Now().UTC()}
+	err := s.InTx(ctx, func(tx *Store) error {
+		groups, err := tx.ListGroups(ctx)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			manifest.Groups = append(manifest.Groups, ManifestGroup{ID: g.ID, Name: g.Name, ParentID: g.ParentID})
+		}
+
+		files, err := tx.ListFiles(ctx, FileFilter{})
+		if err != nil {
+			return err
+		}
+		notes, err := tx.allNotes(ctx)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			parts, err := tx.FileParts(ctx, f.ID)
+			if err != nil {
+				return err
+			}
+			manifest.Files = append(manifest.Files, newManifestFile(f, notes[f.ID], parts))
+		}
+		return nil
+	})
+	return manifest, err
+}
+
+func newManifestFile(f File, note string, parts []Part) ManifestFile {
+	file := ManifestFile{
+		ID:           f.ID,
+		UUID:         f.UUID,
+		Name:         f.Name,
+		Size:         f.Size,
+		Hash:         f.Hash,
+		GroupID:      f.GroupID,
+		UploadedAt:   f.UploadedAt.UTC(),
+		MimeType:     f.MimeType,
+		OriginalPath: f.OriginalPath,
+		Host:         f.Host,
+		Note:         note,
+		Tags:         f.Tags,
+		Parts:        make([]ManifestPart, 0, len(parts)),
+	}
+	if !f.ModTime.IsZero() {
+		modTime := f.ModTime.UTC()
+		file.ModifiedAt = &modTime
+		file.Mode = uint32(f.Mode)
+	}
+	for _, p := range parts {
+		file.Parts = append(file.Parts, ManifestPart{MessageID: p.MessageID, Index: p.Index, Size: p.Size, Hash: p.Hash})
+	}
+	return file
+}
+
+// ConflictPolicy decides what Import does with a file whose name is already taken in its group
+type ConflictPolicy int
+
+const (
+	ConflictRename ConflictPolicy = iota // Import as "name (1).ext", "name (2).ext", ...
+	ConflictSkip                         // Don't import the file
+)
+
+// ImportOptions changes how Import merges a manifest
+type ImportOptions struct {
+	GroupID    int            // Put every file into this group instead of the groups of the manifest, 0 keeps them
+	OnConflict ConflictPolicy // What happens to files whose name is taken in their group
+	DryRun     bool           // Roll everything back at the end, the result shows what would happen
+}
+
+// Status values of a FileImport
+const (
+	ImportAdded   = "imported"
+	ImportRenamed = "renamed"
+	ImportExists  = "exists"  // The file is already in the database, by UUID or by its parts
+	ImportSkipped = "skipped" // The name is taken and the policy is ConflictSkip
+	ImportInvalid = "invalid" // The manifest entry can't be registered
+)
+
+// GroupImport is what Import did with a group of the manifest
+type GroupImport struct {
+	ManifestID int
+	ID         int // ID in the database
+	Name       string
+	Created    bool // False when a group with the name already existed
+}
+
+// FileImport is what Import did with a file of the manifest
+type FileImport struct {
+	ManifestID int
+	ID         int // ID in the database, 0 when skipped or invalid
+	Name       string
+	Status     string
+	Reason     string
+}
+
+// ImportResult lists what Import did with every group and file of the manifest
+type ImportResult struct {
+	Groups []GroupImport
+	Files  []FileImport
+}
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+/*
+Import merges a manifest into the database in one transaction. The IDs of the manifest are
+remapped to the IDs of this database: groups are matched by name and created when missing,
+files that are already registered, found by their UUID or by any of their part messages,
+are left alone so importing the same manifest twice changes nothing.
+*/
+func (s *Store) Import(ctx context.Context, manifest Manifest, opts ImportOptions) (ImportResult, error) {
+	if manifest.Version > ManifestVersion {
+		return ImportResult{}, fmt.Errorf("the manifest is version %d but this disvault only reads up to version %d, update disvault", manifest.Version, ManifestVersion)
+	}
+
+	var result ImportResult
+	err := s.InTx(ctx, func(tx *Store) error {
+		result = ImportResult{}
+		groupIDs := make(map[int]int)
+		if opts.GroupID == 0 {
+			var err error
+			if groupIDs, result.Groups, err = tx.importGroups(ctx, manifest.Groups); err != nil {
+				return err
+			}
+		}
+
+		knownFiles, err := tx.FileIDsByUUID(ctx)
+		if err != nil {
+			return err
+		}
+		partOwners, err := tx.partOwners(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range manifest.Files {
+			groupID := opts.GroupID
+			if groupID == 0 {
+				groupID = groupIDs[f.GroupID]
+			}
+			if groupID == 0 {
+				groupID = DefaultGroupID
+			}
+			record, err := tx.importFile(ctx, f, groupID, opts.OnConflict, knownFiles, partOwners)
+			if err != nil {
+				return err
+			}
+			result.Files = append(result.Files, record)
+		}
+
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	return result, err
+}
+
+// importGroups creates the groups of the manifest that don't exist yet, parents before their children,
+// and maps the manifest IDs to the IDs in the database
+func (s *Store) importGroups(ctx context.Context, groups []ManifestGroup) (map[int]int, []GroupImport, error) {
+	byID := make(map[int]ManifestGroup, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+
+	ids := make(map[int]int, len(groups))
+	var records []GroupImport
+	visiting := make(map[int]bool)
+
+	var resolve func(g ManifestGroup) (int, error)
+	resolve = func(g ManifestGroup) (int, error) {
+		if id, ok := ids[g.ID]; ok {
+			return id, nil
+		}
+		if visiting[g.ID] {
+			return 0, fmt.Errorf("the groups of the manifest form a cycle at '%s'", g.Name)
+		}
+		visiting[g.ID] = true
+
+		record := GroupImport{ManifestID: g.ID, Name: g.Name}
+		id, err := s.GroupByName(ctx, g.Name)
+		switch {
+		case err == nil:
+			record.ID = id
+		case !errors.Is(err, ErrNotFound):
+			return 0, err
+		default:
+			parentID := 0
+			if parent, ok := byID[g.ParentID]; ok && g.ParentID != 0 {
+				if parentID, err = resolve(parent); err != nil {
+					return 0, err
+				}
+			}
+			newID, err := s.CreateGroup(ctx, g.Name, parentID)
+			if err != nil {
+				return 0, err
+			}
+			record.ID = int(newID)
+			record.Created = true
+		}
+
+		ids[g.ID] = record.ID
+		records = append(records, record)
+		return record.ID, nil
+	}
+
+	for _, g := range groups {
+		if _, err := resolve(g); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ids, records, nil
+}
+
+// importFile registers a file of the manifest in the group unless it is already known
+func (s *Store) importFile(ctx context.Context, f ManifestFile, groupID int, policy ConflictPolicy, knownFiles map[string]int, partOwners map[string]int) (FileImport, error) {
+	record := FileImport{ManifestID: f.ID, Name: f.Name}
+
+	if id, ok := knownFiles[f.UUID]; ok && f.UUID != "" {
+		record.ID, record.Status = id, ImportExists
+		return record, nil
+	}
+	for _, p := range f.Parts {
+		if id, ok := partOwners[p.MessageID]; ok {
+			record.ID, record.Status = id, ImportExists
+			record.Reason = fmt.Sprintf("part %s is registered for file %d", p.MessageID, id)
+			return record, nil
+		}
+	}
+	if f.Name == "" || len(f.Parts) == 0 {
+		record.Status, record.Reason = ImportInvalid, "the file has no name or no parts"
+		return record, nil
+	}
+	// The manifest may come from anyone, a download must not write outside of its directory
+	if !ValidFileName(f.Name) {
+		record.Status, record.Reason = ImportInvalid, "the file name isn't a local path"
+		return record, nil
+	}
+
+	record.Status = ImportAdded
+	taken, err := s.nameTaken(ctx, f.Name, groupID)
+	if err != nil {
+		return record, err
+	}
+	if taken {
+		switch policy {
+		case ConflictSkip:
+			record.Status, record.Reason = ImportSkipped, "a file with this name is already in the group"
+			return record, nil
+		case ConflictRename:
+			if record.Name, err = s.freeName(ctx, f.Name, groupID); err != nil {
+				return record, err
+			}
+			record.Status = ImportRenamed
+		}
+	}
+
+	local := &FilesLocal{
+		Name:         record.Name,
+		Total_parts:  len(f.Parts),
+		Size:         f.Size,
+		Hash:         f.Hash,
+		GroupID:      groupID,
+		UUID:         f.UUID,
+		UploadedAt:   f.UploadedAt,
+		MimeType:     f.MimeType,
+		OriginalPath: f.OriginalPath,
+		Host:         f.Host,
+	}
+	if f.ModifiedAt != nil {
+		local.ModTime = *f.ModifiedAt
+		local.Mode = os.FileMode(f.Mode)
+	}
+	parts := &Parts{}
+	for _, p := range f.Parts {
+		parts.Parts = append(parts.Parts, Part{MessageID: p.MessageID, Index: p.Index, Size: p.Size, Hash: p.Hash})
+	}
+
+	// The name is free now, so the file starts its own lineage instead of becoming a version of another one
+	fileID, err := s.insertFile(ctx, local, parts)
+	if err != nil {
+		return record, fmt.Errorf("failed to import '%s': %w", f.Name, err)
+	}
+	record.ID = int(fileID)
+	knownFiles[local.UUID] = record.ID
+	for _, p := range f.Parts {
+		partOwners[p.MessageID] = record.ID
+	}
+
+	if len(f.Tags) > 0 {
+		if err := s.AddTags(ctx, record.ID, f.Tags); err != nil {
+			return record, err
+		}
+	}
+	if f.Note != "" {
+		if err := s.SetNote(ctx, record.ID, f.Note); err != nil {
+			return record, err
+		}
+	}
+	return record, nil
+}
+
+// nameTaken reports whether a file with the name is in the group
+func (s *Store) nameTaken(ctx context.Context, name string, groupID int) (bool, error) {
+	var count int
+	err := s.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE name = ? AND group_id = ?", name, groupID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking file name: %w", err)
+	}
+	return count > 0, nil
+}
+
+// freeName returns the name when it isn't taken in the group, otherwise the first free
+// one of "name (1).ext", "name (2).ext", ...
+func (s *Store) freeName(ctx context.Context, name string, groupID int) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	free := name
+	for i := 1; ; i++ {
+		taken, err := s.nameTaken(ctx, free, groupID)
+		if err != nil || !taken {
+			return free, err
+		}
+		free = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// partOwners maps the message ID of every registered part to its file ID
+func (s *Store) partOwners(ctx context.Context) (map[string]int, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT part_id, file_id FROM parts")
+	if err != nil {
+		return nil, fmt.Errorf("error querying parts: %w", err)
+	}
+	defer rows.Close()
+
+	owners := make(map[string]int)
+	for rows.Next() {
+		var partID string
+		var fileID int
+		if err := rows.Scan(&partID, &fileID); err != nil {
+			return nil, fmt.Errorf("error scanning part: %w", err)
+		}
+		owners[partID] = fileID
+	}
+	return owners, rows.Err()
+}
+
+// allNotes maps the ID of every file with a note to its note
+func (s *Store) allNotes(ctx context.Context) (map[int]string, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT file_id, note FROM file_notes")
+	if err != nil {
+		return nil, fmt.Errorf("error querying notes: %w", err)
+	}
+	defer rows.Close()
+
+	notes := make(map[int]string)
+	for rows.Next() {
+		var fileID int
+		var note sql.NullString
+		if err := rows.Scan(&fileID, &note); err != nil {
+			return nil, fmt.Errorf("error scanning note: %w", err)
+		}
+		notes[fileID] = note.String
+	}
+	return notes, rows.Err()
+}
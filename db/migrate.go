@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Migration is one step of the database schema, the version of a database is stored in
+// PRAGMA user_version and is the version of the last migration applied to it.
+// Migrations are never changed once released, a change to the schema is a new migration at the end.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations in the order they are applied, the version of each is its position starting at 1
+var migrations = []Migration{
+	{1, "baseline schema", migrateBaseline},
+	{2, "repair references for foreign keys", execMigration(`
+		-- Files of groups deleted before foreign keys were enforced go back to 'uncategorized'
+		UPDATE files SET group_id = 1 WHERE group_id NOT IN (SELECT group_id FROM groups);
+		UPDATE groups SET parent_group_id = NULL
+		WHERE parent_group_id IS NOT NULL AND parent_group_id NOT IN (SELECT group_id FROM groups);
+		DELETE FROM file_notes WHERE file_id NOT IN (SELECT id FROM files);
+		DELETE FROM file_tags WHERE file_id NOT IN (SELECT id FROM files);
+		DELETE FROM file_tags WHERE tag_id NOT IN (SELECT tag_id FROM tags);
+		-- Parts of deleted files are left alone, their messages may still be on Discord
+	`)},
+	{3, "file UUIDs for self-describing part messages", migrateFileUUIDs},
+	{4, "file states for resumable deletes", execMigration(`
+		ALTER TABLE files ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
+		CREATE INDEX IF NOT EXISTS idx_file_state ON files(state);
+	`)},
+	{5, "trash", execMigration(`
+		ALTER TABLE files ADD COLUMN deleted_at INTEGER; -- Unix time the file was moved to the trash
+	`)},
+	{6, "file versions", execMigration(`
+		ALTER TABLE files ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE files ADD COLUMN lineage_id INTEGER;                 -- ID of the first version, shared by every version of a file
+		ALTER TABLE files ADD COLUMN current INTEGER NOT NULL DEFAULT 1; -- 0 once a newer version was uploaded
+		UPDATE files SET lineage_id = id;
+		CREATE INDEX IF NOT EXISTS idx_file_lineage ON files(lineage_id);
+	`)},
+	{7, "directory backups", execMigration(`
+		-- Directories backed up with the backup command and the group their files go to
+		CREATE TABLE IF NOT EXISTS backup_roots (
+		 root_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		 path TEXT UNIQUE NOT NULL, -- Absolute path of the directory
+		 group_id INTEGER NOT NULL,
+		 FOREIGN KEY (group_id) REFERENCES groups(group_id) ON DELETE CASCADE
+		);
+
+		-- What the last run saw of every file of a root, unchanged files are detected without reading them
+		CREATE TABLE IF NOT EXISTS backup_entries (
+		 root_id INTEGER NOT NULL,
+		 path TEXT NOT NULL,           -- Relative to the root, separated by slashes
+		 size INTEGER NOT NULL,
+		 modified_ns INTEGER NOT NULL, -- Modification time in Unix nanoseconds
+		 hash TEXT NOT NULL,           -- SHA-256 of the content
+		 file_id INTEGER,              -- Uploaded version, NULL once it was deleted from the vault
+		 removed_at INTEGER,           -- Unix time the file was found missing from the directory
+		 PRIMARY KEY (root_id, path),
+		 FOREIGN KEY (root_id) REFERENCES backup_roots(root_id) ON DELETE CASCADE,
+		 FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE SET NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_backup_entries_file ON backup_entries(file_id);
+
+		-- Every run of the backup command and the files the directory held at that point
+		CREATE TABLE IF NOT EXISTS snapshots (
+		 snapshot_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		 root_id INTEGER NOT NULL,
+		 created_at INTEGER NOT NULL, -- Unix time
+		 added INTEGER NOT NULL DEFAULT 0,
+		 changed INTEGER NOT NULL DEFAULT 0,
+		 unchanged INTEGER NOT NULL DEFAULT 0,
+		 removed INTEGER NOT NULL DEFAULT 0,
+		 failed INTEGER NOT NULL DEFAULT 0,
+		 FOREIGN KEY (root_id) REFERENCES backup_roots(root_id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS snapshot_files (
+		 snapshot_id INTEGER NOT NULL,
+		 path TEXT NOT NULL,
+		 file_id INTEGER, -- NULL once the version was deleted from the vault
+		 PRIMARY KEY (snapshot_id, path),
+		 FOREIGN KEY (snapshot_id) REFERENCES snapshots(snapshot_id) ON DELETE CASCADE,
+		 FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE SET NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_snapshot_files_file ON snapshot_files(file_id);
+	`)},
+	{8, "snapshot names", execMigration(`
+		ALTER TABLE snapshots ADD COLUMN name TEXT; -- Optional, unique among the named snapshots
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_snapshot_name ON snapshots(name);
+	`)},
+}
+
+// execMigration is a migration that runs plain SQL
+func execMigration(query string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+// BackupDir holds the copies of the database taken before migrating it
+var BackupDir = filepath.Join(DataDir, "backups")
+
+// Migrations returns all known migrations in the order they are applied
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+// LatestVersion is the schema version this build of disvault works with
+func LatestVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the version of the database, 0 for a new database
+// or one created before migrations existed
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	if err := s.conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Migrate applies the migrations the database is missing, each in its own transaction.
+// A copy of an existing database is saved to BackupDir first, its path is returned as backup.
+func (s *Store) Migrate(ctx context.Context) (applied []Migration, backup string, err error) {
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if current > LatestVersion() {
+		return nil, "", fmt.Errorf("the database is at version %d but this disvault only knows up to version %d, update disvault", current, LatestVersion())
+	}
+	if current == LatestVersion() {
+		return nil, "", nil
+	}
+
+	var objects int
+	if err := s.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&objects); err != nil {
+		return nil, "", fmt.Errorf("failed to inspect database: %w", err)
+	}
+	// A new database has nothing worth saving
+	if objects > 0 {
+		backup = filepath.Join(BackupDir, fmt.Sprintf("db-v%d-%s.sql", current, time.Now().Format("20060102-150405")))
+		if err := s.BackupTo(ctx, backup); err != nil {
+			return nil, "", fmt.Errorf("failed to back up the database before migrating: %w", err)
+		}
+	}
+
+	for _, migration := range migrations[current:] {
+		if err := s.applyMigration(ctx, migration); err != nil {
+			return applied, backup, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, backup, nil
+}
+
+// applyMigration runs a migration and records its version, nothing is kept if either fails
+func (s *Store) applyMigration(ctx context.Context, migration Migration) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start migration %d: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if err := migration.Up(ctx, tx); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+	}
+	// PRAGMA doesn't take parameters, the version is always a plain number
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", migration.Version)); err != nil {
+		return fmt.Errorf("failed to record schema version %d: %w", migration.Version, err)
+	}
+	return tx.Commit()
+}
+
+// BackupTo writes a consistent copy of the database to path, which must not exist yet
+func (s *Store) BackupTo(ctx context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if _, err := s.conn.ExecContext(ctx, "VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to copy database to %s: %w", path, err)
+	}
+	return nil
+}
+
+// migrateBaseline creates the schema as it was before migrations existed. Databases of those versions
+// have user_version 0 whatever tables they have, so this also upgrades them in ways
+// `CREATE ... IF NOT EXISTS` can't before creating what is missing.
+func migrateBaseline(ctx context.Context, tx *sql.Tx) error {
+	// files_fts gained the tags column, drop the index so Tables creates and fills it again
+	hasTags, err := hasColumn(ctx, tx, "files_fts", "tags")
+	if err != nil {
+		return err
+	}
+	hasIndex, err := hasColumn(ctx, tx, "files_fts", "name")
+	if err != nil {
+		return err
+	}
+	if hasIndex && !hasTags {
+		if _, err := tx.ExecContext(ctx, "DROP TABLE files_fts"); err != nil {
+			return fmt.Errorf("failed to drop the outdated search index: %w", err)
+		}
+	}
+
+	// files and parts gained metadata columns, older files only get their upload time back
+	hasFiles, err := hasColumn(ctx, tx, "files", "id")
+	if err != nil {
+		return err
+	}
+	for _, column := range baselineColumns {
+		if !hasFiles {
+			break // A new database, Tables creates them with all columns
+		}
+		exists, err := hasColumn(ctx, tx, column.table, column.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "ALTER TABLE "+column.table+" ADD COLUMN "+column.name+" "+column.definition)
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", column.table, column.name, err)
+		}
+		if column.name == "uploaded_at" {
+			if _, err := tx.ExecContext(ctx, "UPDATE files SET uploaded_at = "+snowflakeUploadedAtSQL); err != nil {
+				return fmt.Errorf("failed to fill in upload times: %w", err)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, Tables); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+	return nil
+}
+
+// migrateFileUUIDs gives every file the UUID that is written into the headers of its part messages,
+// files uploaded before get one as well so later uploads and rebuilds can refer to them
+func migrateFileUUIDs(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "ALTER TABLE files ADD COLUMN uuid TEXT"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE UNIQUE INDEX idx_file_uuid ON files(uuid)"); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM files")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, "UPDATE files SET uuid = ? WHERE id = ?", NewUUID(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// baselineColumns were added to the tables before migrations existed, with their types
+var baselineColumns = []struct{ table, name, definition string }{
+	{"files", "uploaded_at", "INTEGER"},
+	{"files", "modified_at", "INTEGER"},
+	{"files", "mode", "INTEGER"},
+	{"files", "mime_type", "TEXT"},
+	{"files", "original_path", "TEXT"},
+	{"files", "uploader_host", "TEXT"},
+	{"parts", "part_index", "INTEGER"},
+	{"parts", "size", "INTEGER"},
+	{"parts", "hash", "TEXT"},
+}
+
+// snowflakeUploadedAtSQL is an SQL expression giving the unix time (in seconds) the file `files.id` was uploaded.
+// Discord message IDs are snowflakes that carry their creation time in the upper bits,
+// so the first part of a file tells when the upload happened, used for files uploaded before uploaded_at existed.
+const snowflakeUploadedAtSQL = `(SELECT ((MIN(CAST(p.part_id AS INTEGER)) >> 22) + 1420070400000) / 1000 FROM parts p WHERE p.file_id = files.id)`
+
+// hasColumn reports whether the table exists and has the given column
+func hasColumn(ctx context.Context, tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// legacySchema is the schema of the databases created before migrations existed, at user_version 0
+const legacySchema = `
+	CREATE TABLE groups (
+	 group_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	 group_name TEXT UNIQUE NOT NULL,
+	 parent_group_id INTEGER,
+	 FOREIGN KEY (parent_group_id) REFERENCES groups(group_id)
+	);
+	INSERT INTO groups (group_name) VALUES ('uncategorized'), ('photos');
+	CREATE TABLE files (
+	 id INTEGER PRIMARY KEY AUTOINCREMENT,
+	 name TEXT NOT NULL,
+	 total_parts INTEGER NOT NULL,
+	 size INTEGER NOT NULL,
+	 hash TEXT NOT NULL,
+	 group_id INTEGER NOT NULL DEFAULT 1,
+	 FOREIGN KEY (group_id) REFERENCES groups(group_id)
+	);
+	CREATE TABLE parts (
+	 part_id TEXT PRIMARY KEY,
+	 file_id INTEGER NOT NULL,
+	 FOREIGN KEY (file_id) REFERENCES files(id)
+	);
+	INSERT INTO files (name, total_parts, size, hash, group_id) VALUES ('holiday.jpg', 1, 2048, 'abc123', 2);
+	INSERT INTO parts (part_id, file_id) VALUES ('1234567890123456789', 1);
+`
+
+// openTestDB opens a database for a test, dsn is a file path or :memory:. The pool is limited to one
+// connection since every connection to :memory: would get its own empty database.
+func openTestDB(t *testing.T, dsn string) *Store {
+	t.Helper()
+	conn, err := sql.Open("sqlite", "file:"+dsn+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("open %s: %v", dsn, err)
+	}
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+	return NewStore(conn)
+}
+
+// useTempBackupDir points BackupDir to a directory removed after the test
+func useTempBackupDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	old := BackupDir
+	BackupDir = dir
+	t.Cleanup(func() { BackupDir = old })
+	return dir
+}
+
+func columnExists(t *testing.T, s *Store, table, column string) bool {
+	t.Helper()
+	var count int
+	err := s.conn.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		t.Fatalf("inspect %s: %v", table, err)
+	}
+	return count > 0
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	ctx := context.Background()
+	backupDir := useTempBackupDir(t)
+	s := openTestDB(t, ":memory:")
+
+	applied, backup, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) != LatestVersion() {
+		t.Errorf("applied %d migrations, want %d", len(applied), LatestVersion())
+	}
+	if backup != "" {
+		t.Errorf("a new database was backed up to %s", backup)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(backupDir, "*")); len(matches) != 0 {
+		t.Errorf("backup directory holds %v, want nothing", matches)
+	}
+
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("SchemaVersion: %v", err)
+	}
+	if version != LatestVersion() {
+		t.Errorf("user_version is %d, want %d", version, LatestVersion())
+	}
+	for _, c := range []struct{ table, column string }{
+		{"files", "uploaded_at"}, {"files", "uuid"}, {"files", "state"}, {"files", "deleted_at"},
+		{"files", "version"}, {"files", "lineage_id"}, {"files", "current"}, {"parts", "part_index"},
+		{"backup_entries", "hash"}, {"snapshots", "name"},
+	} {
+		if !columnExists(t, s, c.table, c.column) {
+			t.Errorf("column %s.%s is missing", c.table, c.column)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	backupDir := useTempBackupDir(t)
+	s := openTestDB(t, filepath.Join(t.TempDir(), "db.sql"))
+	if _, err := s.conn.Exec(legacySchema); err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+
+	applied, backup, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) != LatestVersion() {
+		t.Errorf("applied %d migrations, want %d", len(applied), LatestVersion())
+	}
+	if version, _ := s.SchemaVersion(ctx); version != LatestVersion() {
+		t.Errorf("user_version is %d, want %d", version, LatestVersion())
+	}
+
+	// The existing file keeps its data and gets the columns added since
+	var name, uuid, state string
+	var groupID, version, lineageID, current int
+	err = s.conn.QueryRow("SELECT name, group_id, uuid, state, version, lineage_id, current FROM files WHERE id = 1").
+		Scan(&name, &groupID, &uuid, &state, &version, &lineageID, &current)
+	if err != nil {
+		t.Fatalf("read migrated file: %v", err)
+	}
+	if name != "holiday.jpg" || groupID != 2 {
+		t.Errorf("file is %q in group %d, want holiday.jpg in group 2", name, groupID)
+	}
+	if uuid == "" || state != StateActive || version != 1 || lineageID != 1 || current != 1 {
+		t.Errorf("file has uuid %q, state %q, version %d, lineage %d, current %d", uuid, state, version, lineageID, current)
+	}
+	if results, err := s.SearchFiles(ctx, "holiday", 10); err != nil || len(results) != 1 {
+		t.Errorf("SearchFiles found %d files, err %v, want the migrated file in the index", len(results), err)
+	}
+
+	// The copy taken before migrating is the untouched legacy database
+	if backup == "" || filepath.Dir(backup) != backupDir {
+		t.Fatalf("backup is %q, want a file in %s", backup, backupDir)
+	}
+	saved := openTestDB(t, backup)
+	if v, _ := saved.SchemaVersion(ctx); v != 0 {
+		t.Errorf("backup is at version %d, want 0", v)
+	}
+	if columnExists(t, saved, "files", "uuid") {
+		t.Error("backup already has the migrated columns")
+	}
+	var count int
+	if err := saved.conn.QueryRow("SELECT COUNT(*) FROM files").Scan(&count); err != nil || count != 1 {
+		t.Errorf("backup holds %d files, err %v, want 1", count, err)
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	ctx := context.Background()
+	backupDir := useTempBackupDir(t)
+	s := openTestDB(t, filepath.Join(t.TempDir(), "db.sql"))
+	if _, _, err := s.Migrate(ctx); err != nil {
+		t.Fatalf("first Migrate: %v", err)
+	}
+
+	applied, backup, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	if len(applied) != 0 || backup != "" {
+		t.Errorf("second Migrate applied %d migrations and backed up to %q, want nothing", len(applied), backup)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(backupDir, "*")); len(matches) != 0 {
+		t.Errorf("backup directory holds %v, want nothing", matches)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	ctx := context.Background()
+	useTempBackupDir(t)
+	s := openTestDB(t, ":memory:")
+	if _, _, err := s.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if _, err := s.conn.Exec(fmt.Sprintf("PRAGMA user_version = %d", LatestVersion()+1)); err != nil {
+		t.Fatalf("set user_version: %v", err)
+	}
+
+	applied, _, err := s.Migrate(ctx)
+	if err == nil {
+		t.Fatal("Migrate accepted a database newer than this build")
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied %d migrations to a newer database", len(applied))
+	}
+	if version, _ := s.SchemaVersion(ctx); version != LatestVersion()+1 {
+		t.Errorf("user_version changed to %d", version)
+	}
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Note returns the note of a file, the error matches ErrNotFound if the file has none
+func (s *Store) Note(ctx context.Context, fileID int) (string, error) {
+	var note string
+	err := s.q.QueryRowContext(ctx, "SELECT note FROM file_notes WHERE file_id = ?", fileID).Scan(&note)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", notFound("file %d has no note", fileID)
+	case err != nil:
+		return "", fmt.Errorf("error fetching note: %w", err)
+	}
+	return note, nil
+}
+
+// SetNote replaces the note of a file
+func (s *Store) SetNote(ctx context.Context, fileID int, note string) error {
+	_, err := s.q.ExecContext(ctx, `INSERT INTO file_notes (file_id, note) VALUES (?, ?)
+		ON CONFLICT (file_id) DO UPDATE SET note = excluded.note`, fileID, note)
+	if err != nil {
+		return fmt.Errorf("error saving note: %w", err)
+	}
+	return nil
+}
+
+// ClearNote removes the note of a file
+func (s *Store) ClearNote(ctx context.Context, fileID int) error {
+	if _, err := s.q.ExecContext(ctx, "DELETE FROM file_notes WHERE file_id = ?", fileID); err != nil {
+		return fmt.Errorf("error removing note: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// insertParts registers the uploaded parts of a file, use it inside a transaction with the file itself
+func (s *Store) insertParts(ctx context.Context, data Parts) error {
+	// Prepare the insert statement
+	stmt, err := s.q.PrepareContext(ctx, "INSERT INTO parts (part_id, file_id, part_index, size, hash) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	// Iterate over each part and insert into the database
+	for _, part := range data.Parts {
+		_, err := stmt.ExecContext(ctx, part.MessageID, data.FileID, part.Index, part.Size, part.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to insert part %s for file ID %d: %w", part.MessageID, data.FileID, err)
+		}
+	}
+
+	return nil
+}
+
+// PartIDs gets all the fileparts for use by different function,
+// it returns them in orders since discord uses a timestamp as id it works fine
+func (s *Store) PartIDs(ctx context.Context, fileID int) ([]string, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT part_id FROM parts WHERE file_id = ? ORDER BY part_id", fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying parts: %w", err)
+	}
+	defer rows.Close()
+
+	var partIDs []string
+	for rows.Next() {
+		var partID string
+		if err := rows.Scan(&partID); err != nil {
+			return nil, fmt.Errorf("error scanning part_id: %w", err)
+		}
+		partIDs = append(partIDs, partID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return partIDs, nil
+}
+
+// KnownPartIDs returns the IDs of every registered part
+func (s *Store) KnownPartIDs(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT part_id FROM parts")
+	if err != nil {
+		return nil, fmt.Errorf("error querying parts: %w", err)
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool)
+	for rows.Next() {
+		var partID string
+		if err := rows.Scan(&partID); err != nil {
+			return nil, fmt.Errorf("error scanning part_id: %w", err)
+		}
+		known[partID] = true
+	}
+	return known, rows.Err()
+}
+
+// FileParts returns the parts of a file in upload order. Size and hash are empty
+// for files uploaded before they were recorded, the index is then derived from the order.
+func (s *Store) FileParts(ctx context.Context, fileID int) ([]Part, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT part_id, part_index, size, hash FROM parts WHERE file_id = ? ORDER BY part_id", fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying parts: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []Part
+	for rows.Next() {
+		var part Part
+		var index, size sql.NullInt64
+		var hash sql.NullString
+		if err := rows.Scan(&part.MessageID, &index, &size, &hash); err != nil {
+			return nil, fmt.Errorf("error scanning part: %w", err)
+		}
+		part.Index = len(parts)
+		if index.Valid {
+			part.Index = int(index.Int64)
+		}
+		part.Size = size.Int64
+		part.Hash = hash.String
+		parts = append(parts, part)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return parts, nil
+}
+
+// PartsByFile returns the parts of every file in upload order, keyed by file ID, see FileParts
+func (s *Store) PartsByFile(ctx context.Context) (map[int][]Part, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT file_id, part_id, part_index, size, hash FROM parts ORDER BY file_id, part_id")
+	if err != nil {
+		return nil, fmt.Errorf("error querying parts: %w", err)
+	}
+	defer rows.Close()
+
+	parts := make(map[int][]Part)
+	for rows.Next() {
+		var fileID int
+		var part Part
+		var index, size sql.NullInt64
+		var hash sql.NullString
+		if err := rows.Scan(&fileID, &part.MessageID, &index, &size, &hash); err != nil {
+			return nil, fmt.Errorf("error scanning part: %w", err)
+		}
+		part.Index = len(parts[fileID])
+		if index.Valid {
+			part.Index = int(index.Int64)
+		}
+		part.Size = size.Int64
+		part.Hash = hash.String
+		parts[fileID] = append(parts[fileID], part)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return parts, nil
+}
+
+// AddParts registers more parts of an existing file in one transaction, i.e. parts found again in the channel
+func (s *Store) AddParts(ctx context.Context, fileID int, parts []Part) error {
+	return s.InTx(ctx, func(tx *Store) error {
+		return tx.insertParts(ctx, Parts{FileID: fileID, Parts: parts})
+	})
+}
+
+// DeleteParts removes the given parts of a file in one transaction, together with the file itself
+// when deleteFile is set. Notes and tags of the file are removed by triggers.
+func (s *Store) DeleteParts(ctx context.Context, fileID int, partIDs []string, deleteFile bool) error {
+	return s.InTx(ctx, func(tx *Store) error {
+		for _, partID := range partIDs {
+			if _, err := tx.q.ExecContext(ctx, "DELETE FROM parts WHERE part_id = ? AND file_id = ?", partID, fileID); err != nil {
+				return fmt.Errorf("failed to delete part %s from database: %w", partID, err)
+			}
+		}
+		if !deleteFile {
+			return nil
+		}
+
+		var lineageID int
+		if err := tx.q.QueryRowContext(ctx, "SELECT lineage_id FROM files WHERE id = ?", fileID).Scan(&lineageID); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to look up file ID %d: %w", fileID, err)
+		}
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM files WHERE id = ?", fileID); err != nil {
+			return fmt.Errorf("failed to delete file ID %d from database: %w", fileID, err)
+		}
+		// When the current version is gone the newest one left takes its place
+		_, err := tx.q.ExecContext(ctx, `UPDATE files SET current = 1
+			WHERE id = (SELECT id FROM files WHERE lineage_id = ? ORDER BY version DESC LIMIT 1)
+			AND NOT EXISTS (SELECT 1 FROM files WHERE lineage_id = ? AND current = 1)`, lineageID, lineageID)
+		if err != nil {
+			return fmt.Errorf("failed to update the versions of file ID %d: %w", fileID, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// countRows counts the rows of a table matching the condition
+func countRows(t *testing.T, s *Store, table, condition string, args ...interface{}) int {
+	t.Helper()
+	var count int
+	if err := s.conn.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+condition, args...).Scan(&count); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return count
+}
+
+func TestDeletePartsKeepsFile(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	id := registerTestFile(t, s, "movie.mkv", 1, 100)
+	partIDs, err := s.PartIDs(ctx, id)
+	if err != nil || len(partIDs) != 1 {
+		t.Fatalf("PartIDs returned %v, err %v", partIDs, err)
+	}
+
+	if err := s.DeleteParts(ctx, id, partIDs, false); err != nil {
+		t.Fatalf("DeleteParts: %v", err)
+	}
+	if n := countRows(t, s, "parts", "file_id = ?", id); n != 0 {
+		t.Errorf("%d part(s) left", n)
+	}
+	if _, err := s.GetFile(ctx, id); err != nil {
+		t.Errorf("file is gone although deleteFile was false: %v", err)
+	}
+}
+
+func TestDeletePartsRemovesNotesAndTags(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	id := registerTestFile(t, s, "contract.pdf", 1, 100)
+	kept := registerTestFile(t, s, "invoice.pdf", 1, 100)
+	for _, f := range []int{id, kept} {
+		if err := s.AddTags(ctx, f, []string{"legal"}); err != nil {
+			t.Fatalf("AddTags: %v", err)
+		}
+		if err := s.SetNote(ctx, f, "signed"); err != nil {
+			t.Fatalf("SetNote: %v", err)
+		}
+	}
+	partIDs, _ := s.PartIDs(ctx, id)
+
+	if err := s.DeleteParts(ctx, id, partIDs, true); err != nil {
+		t.Fatalf("DeleteParts: %v", err)
+	}
+	if _, err := s.GetFile(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetFile of the deleted file returned %v, want ErrNotFound", err)
+	}
+	if n := countRows(t, s, "file_notes", "file_id = ?", id); n != 0 {
+		t.Errorf("%d note(s) of the deleted file left", n)
+	}
+	if n := countRows(t, s, "file_tags", "file_id = ?", id); n != 0 {
+		t.Errorf("%d tag(s) of the deleted file left", n)
+	}
+	if results, err := s.SearchFiles(ctx, "contract", 10); err != nil || len(results) != 0 {
+		t.Errorf("search still finds %d result(s), err %v", len(results), err)
+	}
+
+	// The other file keeps its note and the shared tag
+	if note, err := s.Note(ctx, kept); err != nil || note != "signed" {
+		t.Errorf("note of the other file is %q, err %v", note, err)
+	}
+	if f, _ := s.GetFile(ctx, kept); len(f.Tags) != 1 {
+		t.Errorf("other file has tags %v, want [legal]", f.Tags)
+	}
+
+	rows, err := s.conn.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		t.Fatalf("foreign_key_check: %v", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		t.Error("foreign key violations after deleting a file")
+	}
+}
+
+func TestDeletePartsPromotesPreviousVersion(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	first := registerTestFile(t, s, "notes.md", 1, 10)
+	second := registerTestFile(t, s, "notes.md", 1, 20)
+	third := registerTestFile(t, s, "notes.md", 1, 30)
+
+	// Deleting an old version leaves the current one alone
+	partIDs, _ := s.PartIDs(ctx, first)
+	if err := s.DeleteParts(ctx, first, partIDs, true); err != nil {
+		t.Fatalf("DeleteParts: %v", err)
+	}
+	if f, _ := s.GetFile(ctx, third); f.Replaced {
+		t.Error("current version was replaced after deleting an old one")
+	}
+
+	// Deleting the current version makes the newest one left current
+	partIDs, _ = s.PartIDs(ctx, third)
+	if err := s.DeleteParts(ctx, third, partIDs, true); err != nil {
+		t.Fatalf("DeleteParts: %v", err)
+	}
+	f, err := s.GetFile(ctx, second)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if f.Replaced {
+		t.Error("version 2 wasn't made current after the current version was deleted")
+	}
+	if n := countRows(t, s, "files", "lineage_id = ? AND current = 1", first); n != 1 {
+		t.Errorf("lineage has %d current versions, want 1", n)
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"modernc.org/sqlite"
+)
+
+// Compiled patterns by their source, a query calls regexp() once for every row
+var (
+	regexpCache   = make(map[string]*regexp.Regexp)
+	regexpCacheMu sync.Mutex
+)
+
+// SQLite parses `x REGEXP y` as a call to regexp(y, x) but doesn't ship the function itself,
+// register one backed by Go's regexp package so queries can use the REGEXP operator.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqlRegexp)
+}
+
+// sqlRegexp implements regexp(pattern, value), NULL values never match
+func sqlRegexp(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("regexp: pattern must be text")
+	}
+
+	var value string
+	switch v := args[1].(type) {
+	case nil:
+		return false, nil
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+
+	regexpCacheMu.Lock()
+	re, ok := regexpCache[pattern]
+	if !ok {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			regexpCacheMu.Unlock()
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		regexpCache[pattern] = re
+	}
+	regexpCacheMu.Unlock()
+
+	return re.MatchString(value), nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// SearchResult is a file matched by the full-text search
+type SearchResult struct {
+	ID          int
+	Name        string
+	Size        int64
+	GroupName   string
+	GroupPath   string
+	Rank        float64 // bm25 rank, lower is a better match
+	Highlighted string  // Name with the matches in [brackets]
+	Tags        string  // Tags with the matches in [brackets]
+	Snippet     string  // Part of the notes around the matches
+}
+
+// SearchFiles runs an FTS5 query over the names, notes and tags of the files and returns the best matches first
+func (s *Store) SearchFiles(ctx context.Context, match string, limit int) ([]SearchResult, error) {
+	// Matches in the name weigh the most, then tags, then notes
+	rows, err := s.q.QueryContext(ctx, `WITH RECURSIVE `+groupPathsSQL+`
+		SELECT f.id, f.name, f.size, COALESCE(g.group_name, ''), COALESCE(gp.path, ''),
+			bm25(files_fts, 10.0, 1.0, 5.0) AS rank,
+			highlight(files_fts, 0, '[', ']'),
+			COALESCE(highlight(files_fts, 2, '[', ']'), ''),
+			snippet(files_fts, 1, '[', ']', '...', 12)
+		FROM files_fts
+		JOIN files f ON f.id = files_fts.rowid
+		LEFT JOIN groups g ON g.group_id = f.group_id
+		LEFT JOIN group_paths gp ON gp.group_id = f.group_id
+		WHERE files_fts MATCH ? AND f.state = ? AND f.current = 1
+		ORDER BY rank
+		LIMIT ?
+	`, match, StateActive, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching files: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ID, &r.Name, &r.Size, &r.GroupName, &r.GroupPath, &r.Rank, &r.Highlighted, &r.Tags, &r.Snippet); err != nil {
+			return nil, fmt.Errorf("error scanning search result: %w", err)
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return results, nil
+}
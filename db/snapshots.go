@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSnapshotExists is returned when a snapshot name is already taken
+var ErrSnapshotExists = errors.New("a snapshot with this name already exists")
+
+// SnapshotFile is a file recorded in a snapshot with the version it had at that point
+type SnapshotFile struct {
+	Path    string // Relative to the root, separated by slashes
+	FileID  int    // 0 when the version was deleted from the vault
+	Size    int64
+	Hash    string
+	Version int
+	State   string
+}
+
+// snapshotSelectSQL selects the columns scanned by scanSnapshot
+const snapshotSelectSQL = `SELECT s.snapshot_id, s.name, s.root_id, r.path, s.created_at,
+		s.added, s.changed, s.unchanged, s.removed, s.failed,
+		(SELECT COUNT(*) FROM snapshot_files sf WHERE sf.snapshot_id = s.snapshot_id),
+		(SELECT COALESCE(SUM(f.size), 0) FROM snapshot_files sf JOIN files f ON f.id = sf.file_id WHERE sf.snapshot_id = s.snapshot_id)
+	FROM snapshots s
+	JOIN backup_roots r ON r.root_id = s.root_id`
+
+func scanSnapshot(scanner interface{ Scan(...interface{}) error }) (Snapshot, error) {
+	var snapshot Snapshot
+	var name sql.NullString
+	var created int64
+	err := scanner.Scan(&snapshot.ID, &name, &snapshot.RootID, &snapshot.RootPath, &created,
+		&snapshot.Added, &snapshot.Changed, &snapshot.Unchanged, &snapshot.Removed, &snapshot.Failed,
+		&snapshot.Files, &snapshot.Size)
+	snapshot.Name = name.String
+	snapshot.Created = time.Unix(created, 0)
+	return snapshot, err
+}
+
+// ListSnapshots returns the snapshots of a root, of every root when rootID is 0, the oldest first
+func (s *Store) ListSnapshots(ctx context.Context, rootID int) ([]Snapshot, error) {
+	query, args := snapshotSelectSQL, []interface{}{}
+	if rootID != 0 {
+		query += " WHERE s.root_id = ?"
+		args = append(args, rootID)
+	}
+	rows, err := s.q.QueryContext(ctx, query+" ORDER BY s.snapshot_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning snapshot: %w", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+// ResolveSnapshot accepts either a snapshot name or a numeric snapshot ID, names are checked first like for groups.
+// The error matches ErrNotFound if there is no such snapshot.
+func (s *Store) ResolveSnapshot(ctx context.Context, ref string) (Snapshot, error) {
+	snapshot, err := scanSnapshot(s.q.QueryRowContext(ctx, snapshotSelectSQL+" WHERE s.name = ?", ref))
+	if err != sql.ErrNoRows {
+		if err != nil {
+			return snapshot, fmt.Errorf("error fetching snapshot: %w", err)
+		}
+		return snapshot, nil
+	}
+
+	id, convErr := strconv.Atoi(ref)
+	if convErr != nil {
+		return snapshot, notFound("no snapshot named '%s'", ref)
+	}
+	snapshot, err = scanSnapshot(s.q.QueryRowContext(ctx, snapshotSelectSQL+" WHERE s.snapshot_id = ?", id))
+	switch {
+	case err == sql.ErrNoRows:
+		return snapshot, notFound("no snapshot found with ID: %d", id)
+	case err != nil:
+		return snapshot, fmt.Errorf("error fetching snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// SnapshotFiles returns the files recorded in a snapshot ordered by their path
+func (s *Store) SnapshotFiles(ctx context.Context, snapshotID int) ([]SnapshotFile, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT sf.path, sf.file_id, f.size, f.hash, f.version, f.state
+		FROM snapshot_files sf
+		LEFT JOIN files f ON f.id = sf.file_id
+		WHERE sf.snapshot_id = ?
+		ORDER BY sf.path`, snapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying snapshot files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []SnapshotFile
+	for rows.Next() {
+		var f SnapshotFile
+		var fileID, size, version sql.NullInt64
+		var hash, state sql.NullString
+		if err := rows.Scan(&f.Path, &fileID, &size, &hash, &version, &state); err != nil {
+			return nil, fmt.Errorf("error scanning snapshot file: %w", err)
+		}
+		f.FileID, f.Size, f.Version = int(fileID.Int64), size.Int64, int(version.Int64)
+		f.Hash, f.State = hash.String, state.String
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// NameSnapshot gives a snapshot a name, an empty name removes it
+func (s *Store) NameSnapshot(ctx context.Context, id int, name string) error {
+	var value interface{}
+	if name != "" {
+		value = name
+	}
+	result, err := s.q.ExecContext(ctx, "UPDATE snapshots SET name = ? WHERE snapshot_id = ?", value, id)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrSnapshotExists
+		}
+		return fmt.Errorf("error naming snapshot: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return notFound("no snapshot found with ID: %d", id)
+	}
+	return nil
+}
+
+// DeleteSnapshot forgets a snapshot, the versions it recorded stay in the vault
+func (s *Store) DeleteSnapshot(ctx context.Context, id int) error {
+	result, err := s.q.ExecContext(ctx, "DELETE FROM snapshots WHERE snapshot_id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting snapshot: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return notFound("no snapshot found with ID: %d", id)
+	}
+	return nil
+}
+
+// SnapshotFileIDs returns the IDs of every file version recorded in a snapshot
+func (s *Store) SnapshotFileIDs(ctx context.Context) (map[int]bool, error) {
+	rows, err := s.q.QueryContext(ctx, "SELECT DISTINCT file_id FROM snapshot_files WHERE file_id IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("error querying snapshot files: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning snapshot file: %w", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is matched, with errors.Is, by the errors returned for files, groups and notes that don't exist
+var ErrNotFound = errors.New("not found")
+
+// notFoundError is an ErrNotFound with a message telling what wasn't found
+type notFoundError struct{ msg string }
+
+func (e notFoundError) Error() string        { return e.msg }
+func (e notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+func notFound(format string, args ...interface{}) error {
+	return notFoundError{fmt.Sprintf(format, args...)}
+}
+
+// querier is what a Store needs to run its queries, both *sql.DB and *sql.Tx provide it
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// Store reads and writes the metadata of files, groups, parts, tags and notes.
+// Commands use Default, the store of data/db.sql opened by InitDatabase, while any other
+// database, i.e. an in-memory one, can be used through NewStore followed by Migrate.
+type Store struct {
+	conn *sql.DB
+	q    querier // conn, or the transaction of a Store handed out by InTx
+}
+
+// Default is the store of data/db.sql, set by Open
+var Default *Store
+
+// NewStore returns a Store working on conn, the schema isn't touched until Migrate is called
+func NewStore(conn *sql.DB) *Store {
+	return &Store{conn: conn, q: conn}
+}
+
+// Conn returns the connection pool of the store
+func (s *Store) Conn() *sql.DB {
+	return s.conn
+}
+
+// InTx runs fn with a Store whose methods all run in one transaction, it is committed when fn
+// returns nil and rolled back otherwise. Calls on a Store that is already in a transaction join it.
+func (s *Store) InTx(ctx context.Context, fn func(tx *Store) error) error {
+	if _, ok := s.q.(*sql.Tx); ok {
+		return fn(s)
+	}
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Store{conn: s.conn, q: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a migrated store on a new in-memory database
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	old := Messages
+	Messages = io.Discard
+	t.Cleanup(func() { Messages = old })
+
+	s := openTestDB(t, ":memory:")
+	if _, _, err := s.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+// partCounter makes the message IDs of test parts unique across a test binary
+var partCounter int
+
+// registerTestFile registers a file with a single part and returns its ID
+func registerTestFile(t *testing.T, s *Store, name string, groupID int, size int64) int {
+	t.Helper()
+	partCounter++
+	file := &FilesLocal{
+		Name:        name,
+		Total_parts: 1,
+		Size:        size,
+		Hash:        fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprint(name, partCounter)))),
+		GroupID:     groupID,
+		UploadedAt:  time.Unix(1700000000+int64(partCounter), 0),
+	}
+	parts := &Parts{Parts: []Part{{MessageID: fmt.Sprint(1000000000000000000 + partCounter), Size: size}}}
+	id, err := s.RegisterFile(context.Background(), file, parts)
+	if err != nil {
+		t.Fatalf("RegisterFile %s: %v", name, err)
+	}
+	return int(id)
+}
+
+// createTestGroup creates a group and returns its ID
+func createTestGroup(t *testing.T, s *Store, name string, parentID int) int {
+	t.Helper()
+	id, err := s.CreateGroup(context.Background(), name, parentID)
+	if err != nil {
+		t.Fatalf("CreateGroup %s: %v", name, err)
+	}
+	return int(id)
+}
+
+// fileIDs returns the IDs of the files in their order
+func fileIDs(files []File) []int {
+	ids := make([]int, len(files))
+	for i, f := range files {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+func TestInTxCommits(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	err := s.InTx(ctx, func(tx *Store) error {
+		_, err := tx.CreateGroup(ctx, "photos", 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("InTx: %v", err)
+	}
+	if _, err := s.GroupByName(ctx, "photos"); err != nil {
+		t.Errorf("group created in the transaction is missing: %v", err)
+	}
+}
+
+func TestInTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	id := registerTestFile(t, s, "report.pdf", 1, 100)
+
+	errFailed := errors.New("failed on purpose")
+	err := s.InTx(ctx, func(tx *Store) error {
+		if _, err := tx.CreateGroup(ctx, "photos", 0); err != nil {
+			return err
+		}
+		if err := tx.RenameFile(ctx, id, "renamed.pdf"); err != nil {
+			return err
+		}
+		// Nested calls join the transaction and are rolled back with it
+		if err := tx.AddTags(ctx, id, []string{"tax"}); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("InTx returned %v, want the error of fn", err)
+	}
+
+	if _, err := s.GroupByName(ctx, "photos"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("group of the rolled back transaction exists, err %v", err)
+	}
+	file, err := s.GetFile(ctx, id)
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	if file.Name != "report.pdf" || len(file.Tags) != 0 {
+		t.Errorf("file is %q with tags %v after the rollback, want report.pdf without tags", file.Name, file.Tags)
+	}
+}
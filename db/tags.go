@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tag is a tag with the number of files carrying it
+type Tag struct {
+	Name  string
+	Files int
+}
+
+// AddTags puts the tags on a file, creating the tags that don't exist yet
+func (s *Store) AddTags(ctx context.Context, fileID int, tags []string) error {
+	return s.InTx(ctx, func(tx *Store) error {
+		for _, tag := range tags {
+			if _, err := tx.q.ExecContext(ctx, "INSERT INTO tags (tag_name) VALUES (?) ON CONFLICT (tag_name) DO NOTHING", tag); err != nil {
+				return fmt.Errorf("error creating tag '%s': %w", tag, err)
+			}
+			_, err := tx.q.ExecContext(ctx, `INSERT INTO file_tags (file_id, tag_id)
+				SELECT ?, tag_id FROM tags WHERE tag_name = ?
+				ON CONFLICT (file_id, tag_id) DO NOTHING`, fileID, tag)
+			if err != nil {
+				return fmt.Errorf("error tagging file: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveTags takes the tags off a file, tags that aren't used by any file anymore are removed altogether
+func (s *Store) RemoveTags(ctx context.Context, fileID int, tags []string) error {
+	return s.InTx(ctx, func(tx *Store) error {
+		for _, tag := range tags {
+			_, err := tx.q.ExecContext(ctx, `DELETE FROM file_tags
+				WHERE file_id = ? AND tag_id = (SELECT tag_id FROM tags WHERE tag_name = ?)`, fileID, tag)
+			if err != nil {
+				return fmt.Errorf("error removing tag '%s': %w", tag, err)
+			}
+		}
+
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM tags WHERE tag_id NOT IN (SELECT tag_id FROM file_tags)"); err != nil {
+			return fmt.Errorf("error removing unused tags: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListTags returns every tag in alphabetical order
+func (s *Store) ListTags(ctx context.Context) ([]Tag, error) {
+	return s.queryTags(ctx, `SELECT t.tag_name, COUNT(ft.file_id)
+		FROM tags t
+		LEFT JOIN file_tags ft ON ft.tag_id = t.tag_id
+		GROUP BY t.tag_id
+		ORDER BY t.tag_name`)
+}
+
+// FileTags returns the tags of a file in alphabetical order, counting every file that has them
+func (s *Store) FileTags(ctx context.Context, fileID int) ([]Tag, error) {
+	return s.queryTags(ctx, `SELECT t.tag_name, (SELECT COUNT(*) FROM file_tags c WHERE c.tag_id = t.tag_id)
+		FROM file_tags ft
+		JOIN tags t ON t.tag_id = ft.tag_id
+		WHERE ft.file_id = ?
+		ORDER BY t.tag_name`, fileID)
+}
+
+// queryTags reads tags selected as name and file count
+func (s *Store) queryTags(ctx context.Context, query string, args ...interface{}) ([]Tag, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Files); err != nil {
+			return nil, fmt.Errorf("error scanning tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return tags, nil
+}
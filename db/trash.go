@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TrashFiles moves active files to the trash together with their older versions, their parts stay on Discord
+// until the trash is emptied. It returns how many of the given files were moved.
+func (s *Store) TrashFiles(ctx context.Context, ids []int) (int64, error) {
+	return s.setTrashState(ctx, ids, StateActive, StateTrashed, time.Now().Unix())
+}
+
+// RestoreFiles moves files out of the trash together with their older versions, it returns how many of the given files were restored
+func (s *Store) RestoreFiles(ctx context.Context, ids []int) (int64, error) {
+	return s.setTrashState(ctx, ids, StateTrashed, StateActive, nil)
+}
+
+// setTrashState moves the files in state from and all of their versions to state to and sets their deletion time
+func (s *Store) setTrashState(ctx context.Context, ids []int, from, to string, deletedAt interface{}) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	params := []interface{}{from}
+	for _, id := range ids {
+		params = append(params, id)
+	}
+
+	var moved int64
+	err := s.InTx(ctx, func(tx *Store) error {
+		if err := tx.q.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE state = ? AND id IN "+in, params...).Scan(&moved); err != nil {
+			return fmt.Errorf("error counting files: %w", err)
+		}
+		_, err := tx.q.ExecContext(ctx,
+			"UPDATE files SET state = ?, deleted_at = ? WHERE state = ? AND lineage_id IN (SELECT lineage_id FROM files WHERE id IN "+in+")",
+			append([]interface{}{to, deletedAt}, params...)...,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to move files to the %s state: %w", to, err)
+		}
+		return nil
+	})
+	return moved, err
+}